@@ -0,0 +1,135 @@
+// Copyright 2022 Mineiros GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eval
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/mineiros-io/terramate/project"
+)
+
+// ChangeKind classifies an ObjectChange.
+type ChangeKind int
+
+// Kinds of changes Diff can report.
+const (
+	// ChangeAdd means the key only exists in the Object Diff was called
+	// with (the "new" side).
+	ChangeAdd ChangeKind = iota
+	// ChangeRemove means the key only exists in the receiver (the "old"
+	// side).
+	ChangeRemove
+	// ChangeChange means the key exists on both sides with different
+	// values.
+	ChangeChange
+)
+
+// ObjectChange describes one difference found by Diff.
+type ObjectChange struct {
+	Path ObjectPath
+	Kind ChangeKind
+
+	// OldOrigin/NewOrigin are the origins of the value on each side, so
+	// callers can tell users which file/override introduced a change.
+	// They're the zero project.Path on the side a ChangeAdd/ChangeRemove
+	// doesn't have.
+	OldOrigin project.Path
+	NewOrigin project.Path
+}
+
+// Diff compares obj (the "old" side) against other (the "new" side) and
+// returns every add/remove/change found, recursing into nested *Object
+// values. This is what powers showing a user what a globals override
+// changed, and is the comparison an override_*.tm.hcl mechanism needs to
+// validate what it's overriding.
+func (obj *Object) Diff(other *Object) []ObjectChange {
+	changes := diffAt(nil, obj, other)
+	sortChanges(changes)
+	return changes
+}
+
+// sortChanges orders changes by Path so that Diff's result is deterministic
+// regardless of Go's randomized map iteration order, the same concern
+// sortManifest addresses for ModuleManifest.
+func sortChanges(changes []ObjectChange) {
+	sort.Slice(changes, func(i, j int) bool {
+		return strings.Join(changes[i].Path, ".") < strings.Join(changes[j].Path, ".")
+	})
+}
+
+func diffAt(prefix ObjectPath, oldObj, newObj *Object) []ObjectChange {
+	var changes []ObjectChange
+
+	for key, oldVal := range oldObj.Keys {
+		path := append(append(ObjectPath{}, prefix...), key)
+
+		newVal, ok := newObj.Keys[key]
+		if !ok {
+			changes = append(changes, ObjectChange{
+				Path:      path,
+				Kind:      ChangeRemove,
+				OldOrigin: oldVal.Origin(),
+			})
+			continue
+		}
+
+		changes = append(changes, diffValue(path, oldVal, newVal)...)
+	}
+
+	for key, newVal := range newObj.Keys {
+		if _, ok := oldObj.Keys[key]; ok {
+			continue
+		}
+		path := append(append(ObjectPath{}, prefix...), key)
+		changes = append(changes, ObjectChange{
+			Path:      path,
+			Kind:      ChangeAdd,
+			NewOrigin: newVal.Origin(),
+		})
+	}
+
+	return changes
+}
+
+func diffValue(path ObjectPath, oldVal, newVal Value) []ObjectChange {
+	oldObj, oldIsObj := oldVal.(*Object)
+	newObj, newIsObj := newVal.(*Object)
+	if oldIsObj && newIsObj {
+		return diffAt(path, oldObj, newObj)
+	}
+
+	if oldIsObj != newIsObj {
+		return []ObjectChange{{
+			Path:      path,
+			Kind:      ChangeChange,
+			OldOrigin: oldVal.Origin(),
+			NewOrigin: newVal.Origin(),
+		}}
+	}
+
+	oldCty := oldVal.(CtyValue)
+	newCty := newVal.(CtyValue)
+	if oldCty.Raw().RawEquals(newCty.Raw()) {
+		return nil
+	}
+
+	return []ObjectChange{{
+		Path:      path,
+		Kind:      ChangeChange,
+		OldOrigin: oldVal.Origin(),
+		NewOrigin: newVal.Origin(),
+	}}
+}
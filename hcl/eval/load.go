@@ -0,0 +1,63 @@
+// Copyright 2022 Mineiros GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eval
+
+import (
+	"github.com/hashicorp/hcl/v2/hclparse"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/mineiros-io/terramate/errors"
+	"github.com/mineiros-io/terramate/hcl"
+	"github.com/mineiros-io/terramate/hcl/ast"
+	"github.com/mineiros-io/terramate/project"
+)
+
+// ErrLoad indicates a file's top-level attributes could not be loaded into
+// an Object.
+const ErrLoad errors.Kind = "loading object from file"
+
+// LoadObject reads path through fsys and evaluates its top-level attributes
+// into a new Object with the given origin. It's how globals and other flat
+// attribute files get their first *Object built, before any merging with
+// parent scopes happens.
+func LoadObject(fsys hcl.FS, path string, origin project.Path) (*Object, error) {
+	src, err := hcl.ReadFile(fsys, path)
+	if err != nil {
+		return nil, errors.E(ErrLoad, err, "reading %q", path)
+	}
+
+	p := hclparse.NewParser()
+	f, diags := p.ParseHCL(src, path)
+	if diags.HasErrors() {
+		return nil, errors.E(ErrLoad, diags)
+	}
+
+	body, ok := f.Body.(*hclsyntax.Body)
+	if !ok {
+		return nil, errors.E(ErrLoad, "%q does not have a native HCL syntax body", path)
+	}
+
+	obj := NewObject(origin)
+
+	attrs := ast.AsHCLAttributes(body.Attributes)
+	for _, attr := range ast.SortRawAttributes(attrs) {
+		val, diags := attr.Expr.Value(nil)
+		if diags.HasErrors() {
+			return nil, errors.E(ErrLoad, diags, "evaluating attribute %q", attr.Name)
+		}
+		obj.Set(attr.Name, NewValue(val, origin))
+	}
+
+	return obj, nil
+}
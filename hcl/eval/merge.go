@@ -0,0 +1,113 @@
+// Copyright 2022 Mineiros GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eval
+
+import (
+	"github.com/mineiros-io/terramate/errors"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// MergeStrategy controls how Merge resolves key collisions between two
+// Objects.
+type MergeStrategy int
+
+const (
+	// MergeReplace makes keys from the incoming Object win over the
+	// receiver's, the same behavior Set already has. This is the default
+	// Terraform/HCL override semantic: the later source wins.
+	MergeReplace MergeStrategy = iota
+	// MergeError fails with ErrMergeConflict on any key collision, the
+	// panic-free equivalent of what SetFrom currently panics for.
+	MergeError
+	// MergeDeep recurses into nested *Object values on both sides and
+	// merges them key by key instead of replacing the whole subtree;
+	// collisions between two list-typed CtyValues are resolved by
+	// concatenating the receiver's list followed by the incoming one.
+	// Any other colliding, non-object value pair falls back to
+	// MergeReplace semantics.
+	MergeDeep
+)
+
+// ErrMergeConflict is returned by Merge in MergeError mode when both
+// objects declare the same key.
+const ErrMergeConflict errors.Kind = "merge conflict"
+
+// Merge combines other into obj in place, according to strategy.
+func (obj *Object) Merge(other *Object, strategy MergeStrategy) error {
+	errs := errors.L()
+
+	for key, incoming := range other.Keys {
+		existing, collides := obj.Keys[key]
+		if !collides {
+			obj.Set(key, incoming)
+			continue
+		}
+
+		switch strategy {
+		case MergeReplace:
+			obj.Set(key, incoming)
+		case MergeError:
+			errs.Append(errors.E(ErrMergeConflict, "key %q already set", key))
+		case MergeDeep:
+			merged, err := mergeDeep(existing, incoming)
+			if err != nil {
+				errs.Append(errors.E(err, "merging key %q", key))
+				continue
+			}
+			obj.Set(key, merged)
+		default:
+			errs.Append(errors.E("unknown merge strategy %v", strategy))
+		}
+	}
+
+	return errs.AsError()
+}
+
+func mergeDeep(existing, incoming Value) (Value, error) {
+	existingObj, existingIsObj := existing.(*Object)
+	incomingObj, incomingIsObj := incoming.(*Object)
+
+	if existingIsObj && incomingIsObj {
+		merged := NewObject(incoming.Origin())
+		merged.SetFrom(existingObj.Keys)
+		if err := merged.Merge(incomingObj, MergeDeep); err != nil {
+			return nil, err
+		}
+		return merged, nil
+	}
+
+	existingCty, existingIsCty := existing.(CtyValue)
+	incomingCty, incomingIsCty := incoming.(CtyValue)
+	if existingIsCty && incomingIsCty &&
+		existingCty.Raw().Type().IsListType() && incomingCty.Raw().Type().IsListType() {
+		// A list-typed value can still be null (eg. "mylist = null"), and
+		// AsValueSlice/LengthInt panic on a null value regardless of type,
+		// so neither side can be concatenated in that case. Fall back to
+		// MergeReplace semantics instead of panicking.
+		if existingCty.Raw().IsNull() || incomingCty.Raw().IsNull() {
+			return incoming, nil
+		}
+
+		elems := append(existingCty.Raw().AsValueSlice(), incomingCty.Raw().AsValueSlice()...)
+		if len(elems) == 0 {
+			return NewCtyValue(existingCty.Raw(), incoming.Origin()), nil
+		}
+		return NewCtyValue(cty.ListVal(elems), incoming.Origin()), nil
+	}
+
+	// Neither both objects nor both lists: later source wins, as in
+	// MergeReplace.
+	return incoming, nil
+}
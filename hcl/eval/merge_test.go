@@ -0,0 +1,156 @@
+// Copyright 2022 Mineiros GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eval_test
+
+import (
+	"testing"
+
+	"github.com/madlambda/spells/assert"
+	"github.com/mineiros-io/terramate/hcl/eval"
+	"github.com/mineiros-io/terramate/project"
+	"github.com/zclconf/go-cty/cty"
+)
+
+const testOrigin = project.Path("/stack")
+
+func TestMergeReplaceOverridesCollidingKeys(t *testing.T) {
+	obj := eval.NewObject(testOrigin)
+	obj.Set("a", eval.NewCtyValue(cty.StringVal("old"), testOrigin))
+
+	other := eval.NewObject(testOrigin)
+	other.Set("a", eval.NewCtyValue(cty.StringVal("new"), testOrigin))
+	other.Set("b", eval.NewCtyValue(cty.StringVal("b"), testOrigin))
+
+	err := obj.Merge(other, eval.MergeReplace)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	a := obj.Keys["a"].(eval.CtyValue).Raw()
+	assert.EqualStrings(t, "new", a.AsString())
+
+	b := obj.Keys["b"].(eval.CtyValue).Raw()
+	assert.EqualStrings(t, "b", b.AsString())
+}
+
+func TestMergeErrorFailsOnCollision(t *testing.T) {
+	obj := eval.NewObject(testOrigin)
+	obj.Set("a", eval.NewCtyValue(cty.StringVal("old"), testOrigin))
+
+	other := eval.NewObject(testOrigin)
+	other.Set("a", eval.NewCtyValue(cty.StringVal("new"), testOrigin))
+
+	err := obj.Merge(other, eval.MergeError)
+	if err == nil {
+		t.Fatal("expected an error, got nil")
+	}
+
+	// the colliding key must keep the receiver's value.
+	a := obj.Keys["a"].(eval.CtyValue).Raw()
+	assert.EqualStrings(t, "old", a.AsString())
+}
+
+func TestMergeErrorDoesNotFailOnNewKeys(t *testing.T) {
+	obj := eval.NewObject(testOrigin)
+	obj.Set("a", eval.NewCtyValue(cty.StringVal("old"), testOrigin))
+
+	other := eval.NewObject(testOrigin)
+	other.Set("b", eval.NewCtyValue(cty.StringVal("b"), testOrigin))
+
+	err := obj.Merge(other, eval.MergeError)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	b := obj.Keys["b"].(eval.CtyValue).Raw()
+	assert.EqualStrings(t, "b", b.AsString())
+}
+
+func TestMergeDeepRecursesIntoNestedObjects(t *testing.T) {
+	obj := eval.NewObject(testOrigin)
+	nested := eval.NewObject(testOrigin)
+	nested.Set("x", eval.NewCtyValue(cty.StringVal("old"), testOrigin))
+	obj.Set("nested", nested)
+
+	otherNested := eval.NewObject(testOrigin)
+	otherNested.Set("x", eval.NewCtyValue(cty.StringVal("new"), testOrigin))
+	otherNested.Set("y", eval.NewCtyValue(cty.StringVal("y"), testOrigin))
+	other := eval.NewObject(testOrigin)
+	other.Set("nested", otherNested)
+
+	err := obj.Merge(other, eval.MergeDeep)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	got := obj.Keys["nested"].(*eval.Object)
+	x := got.Keys["x"].(eval.CtyValue).Raw()
+	assert.EqualStrings(t, "new", x.AsString())
+	y := got.Keys["y"].(eval.CtyValue).Raw()
+	assert.EqualStrings(t, "y", y.AsString())
+}
+
+func TestMergeDeepConcatenatesLists(t *testing.T) {
+	obj := eval.NewObject(testOrigin)
+	obj.Set("l", eval.NewCtyValue(cty.ListVal([]cty.Value{cty.StringVal("a")}), testOrigin))
+
+	other := eval.NewObject(testOrigin)
+	other.Set("l", eval.NewCtyValue(cty.ListVal([]cty.Value{cty.StringVal("b")}), testOrigin))
+
+	err := obj.Merge(other, eval.MergeDeep)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	l := obj.Keys["l"].(eval.CtyValue).Raw()
+	assert.EqualInts(t, 2, l.LengthInt())
+}
+
+func TestMergeDeepFallsBackToReplaceOnNullList(t *testing.T) {
+	listTy := cty.List(cty.String)
+
+	obj := eval.NewObject(testOrigin)
+	obj.Set("l", eval.NewCtyValue(cty.NullVal(listTy), testOrigin))
+
+	other := eval.NewObject(testOrigin)
+	incoming := cty.ListVal([]cty.Value{cty.StringVal("b")})
+	other.Set("l", eval.NewCtyValue(incoming, testOrigin))
+
+	err := obj.Merge(other, eval.MergeDeep)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	l := obj.Keys["l"].(eval.CtyValue).Raw()
+	assert.EqualInts(t, 1, l.LengthInt())
+}
+
+func TestMergeDeepFallsBackToReplaceOnIncomingNullList(t *testing.T) {
+	listTy := cty.List(cty.String)
+
+	obj := eval.NewObject(testOrigin)
+	obj.Set("l", eval.NewCtyValue(cty.ListVal([]cty.Value{cty.StringVal("a")}), testOrigin))
+
+	other := eval.NewObject(testOrigin)
+	other.Set("l", eval.NewCtyValue(cty.NullVal(listTy), testOrigin))
+
+	err := obj.Merge(other, eval.MergeDeep)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	l := obj.Keys["l"].(eval.CtyValue).Raw()
+	assert.IsTrue(t, l.IsNull())
+}
@@ -112,6 +112,8 @@ func (obj *Object) Origin() project.Path { return obj.origin }
 func (obj *Object) IsObject() bool { return true }
 
 // SetFrom sets the object keys and values from the map.
+// It panics on any key collision; use [Object.Merge] with MergeError if you
+// need to handle that case without panicking.
 func (obj *Object) SetFrom(values map[string]Value) *Object {
 	for k, v := range values {
 		if _, ok := obj.Keys[k]; ok {
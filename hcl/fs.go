@@ -0,0 +1,31 @@
+// Copyright 2022 Mineiros GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package hcl provides the filesystem and snapshot primitives shared by the
+// packages that parse Terramate and Terraform HCL (tf, hcl/eval, ...).
+package hcl
+
+import "io/fs"
+
+// FS is the filesystem abstraction every HCL-consuming package reads
+// through. It is exactly io/fs.FS: the real OS tree (os.DirFS), an
+// in-memory map for tests, or a Snapshot loaded from disk all satisfy it
+// without adaptation.
+type FS = fs.FS
+
+// ReadFile reads the named file from fsys. It's a thin alias for
+// fs.ReadFile kept here so callers only need to import this package.
+func ReadFile(fsys FS, name string) ([]byte, error) {
+	return fs.ReadFile(fsys, name)
+}
@@ -0,0 +1,177 @@
+// Copyright 2022 Mineiros GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hcl
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// OSFS is an FS backed by the real operating system filesystem. Unlike
+// os.DirFS it is not rooted at a single directory: names are passed to
+// os.Open/os.Stat/os.ReadDir verbatim, so absolute paths work exactly as
+// they do everywhere else in this codebase.
+type OSFS struct{}
+
+// Open implements FS.
+func (OSFS) Open(name string) (fs.File, error) { return os.Open(name) }
+
+// Stat implements fs.StatFS.
+func (OSFS) Stat(name string) (fs.FileInfo, error) { return os.Stat(name) }
+
+// ReadDir implements fs.ReadDirFS.
+func (OSFS) ReadDir(name string) ([]fs.DirEntry, error) { return os.ReadDir(name) }
+
+// MemFS is an in-memory FS, primarily useful for tests that shouldn't have
+// to touch disk to exercise the tf and eval parsing paths.
+type MemFS map[string][]byte
+
+// Open implements FS.
+func (m MemFS) Open(name string) (fs.File, error) {
+	data, ok := m[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return &memFile{name: name, data: data}, nil
+}
+
+// Stat implements fs.StatFS. A name with no literal entry is reported as a
+// directory if it prefixes some stored file, so that MemFS can back
+// directory-walking consumers such as tf.LoadModule.
+func (m MemFS) Stat(name string) (fs.FileInfo, error) {
+	return statFlatFS(m, name)
+}
+
+// ReadDir implements fs.ReadDirFS, returning the direct children of name.
+func (m MemFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	return readDirFlatFS(m, name)
+}
+
+type memDirEntry struct{ memFileInfo }
+
+func (e memDirEntry) Type() fs.FileMode          { return e.Mode() }
+func (e memDirEntry) Info() (fs.FileInfo, error) { return e.memFileInfo, nil }
+
+// statFlatFS and readDirFlatFS synthesize fs.StatFS/fs.ReadDirFS behavior
+// over a flat map[string][]byte keyed by slash-separated paths, the shape
+// both MemFS and Snapshot store their captured files in: a path with no
+// literal entry is treated as a directory if it prefixes some stored path,
+// mirroring how a real filesystem has implicit directories for any path
+// containing files.
+func statFlatFS(files map[string][]byte, name string) (fs.FileInfo, error) {
+	if data, ok := files[name]; ok {
+		return memFileInfo{name: path.Base(name), size: int64(len(data))}, nil
+	}
+
+	prefix := strings.TrimSuffix(name, "/") + "/"
+	for p := range files {
+		if strings.HasPrefix(p, prefix) {
+			return memFileInfo{name: path.Base(name), dir: true}, nil
+		}
+	}
+
+	return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+}
+
+func readDirFlatFS(files map[string][]byte, name string) ([]fs.DirEntry, error) {
+	prefix := strings.TrimSuffix(name, "/") + "/"
+	seen := map[string]bool{}
+	var entries []fs.DirEntry
+	for p, data := range files {
+		if !strings.HasPrefix(p, prefix) {
+			continue
+		}
+		rest := strings.TrimPrefix(p, prefix)
+		isDir := false
+		if i := strings.IndexByte(rest, '/'); i >= 0 {
+			rest = rest[:i]
+			isDir = true
+		}
+		if seen[rest] {
+			continue
+		}
+		seen[rest] = true
+
+		info := memFileInfo{name: rest, dir: isDir}
+		if !isDir {
+			info.size = int64(len(data))
+		}
+		entries = append(entries, memDirEntry{info})
+	}
+	if entries == nil {
+		if _, err := statFlatFS(files, name); err != nil {
+			return nil, err
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
+
+// OverlayFS layers Upper over Lower: a lookup checks Upper first and only
+// falls back to Lower when the entry is absent there. This is how generated
+// files get layered over the working tree without copying the tree itself.
+type OverlayFS struct {
+	Upper FS
+	Lower FS
+}
+
+// Open implements FS.
+func (o OverlayFS) Open(name string) (fs.File, error) {
+	f, err := o.Upper.Open(name)
+	if err == nil {
+		return f, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	return o.Lower.Open(name)
+}
+
+// Stat implements fs.StatFS.
+func (o OverlayFS) Stat(name string) (fs.FileInfo, error) {
+	if info, err := fs.Stat(o.Upper, name); err == nil {
+		return info, nil
+	}
+	return fs.Stat(o.Lower, name)
+}
+
+// ReadDir implements fs.ReadDirFS, merging both layers with Upper entries
+// taking precedence over same-named Lower entries.
+func (o OverlayFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	lower, lowerErr := fs.ReadDir(o.Lower, name)
+	upper, upperErr := fs.ReadDir(o.Upper, name)
+	if lowerErr != nil && upperErr != nil {
+		return nil, upperErr
+	}
+
+	byName := map[string]fs.DirEntry{}
+	for _, e := range lower {
+		byName[e.Name()] = e
+	}
+	for _, e := range upper {
+		byName[e.Name()] = e
+	}
+
+	entries := make([]fs.DirEntry, 0, len(byName))
+	for _, e := range byName {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
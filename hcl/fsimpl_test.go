@@ -0,0 +1,101 @@
+// Copyright 2022 Mineiros GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hcl_test
+
+import (
+	"io/fs"
+	"testing"
+
+	"github.com/madlambda/spells/assert"
+	"github.com/mineiros-io/terramate/hcl"
+	"github.com/mineiros-io/terramate/tf"
+)
+
+func TestMemFSStatSynthesizesDirectories(t *testing.T) {
+	fsys := hcl.MemFS{
+		"module/main.tf":    []byte(`variable "name" {}`),
+		"module/child/x.tf": []byte(``),
+	}
+
+	info, err := fs.Stat(fsys, "module")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assert.IsTrue(t, info.IsDir())
+	assert.EqualStrings(t, "module", info.Name())
+
+	info, err = fs.Stat(fsys, "module/main.tf")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assert.IsTrue(t, !info.IsDir())
+
+	if _, err := fs.Stat(fsys, "does/not/exist"); err == nil {
+		t.Fatal("expected an error, got nil")
+	}
+}
+
+func TestMemFSBacksLoadModule(t *testing.T) {
+	fsys := hcl.MemFS{
+		"module/main.tf": []byte(`
+variable "name" {
+  type = string
+}
+
+module "child" {
+  source = "./child"
+}
+`),
+	}
+
+	manifest, err := tf.LoadModule(fsys, "module")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	assert.EqualInts(t, 1, len(manifest.Variables))
+	assert.EqualStrings(t, "name", manifest.Variables[0].Name)
+	assert.EqualStrings(t, "string", manifest.Variables[0].Type)
+
+	assert.EqualInts(t, 1, len(manifest.ModuleCalls))
+	assert.EqualStrings(t, "child", manifest.ModuleCalls[0].Name)
+}
+
+func TestOverlayFSUpperTakesPrecedenceOverLower(t *testing.T) {
+	lower := hcl.MemFS{
+		"module/main.tf":  []byte(`variable "lower_only" {}`),
+		"module/lower.tf": []byte(``),
+	}
+	upper := hcl.MemFS{
+		"module/main.tf": []byte(`variable "upper" {}`),
+	}
+	fsys := hcl.OverlayFS{Upper: upper, Lower: lower}
+
+	data, err := hcl.ReadFile(fsys, "module/main.tf")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assert.EqualStrings(t, `variable "upper" {}`, string(data))
+
+	entries, err := fs.ReadDir(fsys, "module")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assert.EqualInts(t, 2, len(entries))
+
+	if _, err := fs.Stat(fsys, "module/lower.tf"); err != nil {
+		t.Fatalf("expected lower-only file to be visible through overlay: %v", err)
+	}
+}
@@ -0,0 +1,328 @@
+// Copyright 2022 Mineiros GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hcl
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mineiros-io/terramate/errors"
+)
+
+// Errors returned while capturing or loading a Snapshot.
+const (
+	ErrSnapshotCorrupted errors.Kind = "hcl snapshot corrupted"
+)
+
+const (
+	snapshotManifestName = "manifest.json"
+	snapshotFilesDir     = "files"
+)
+
+// maxSnapshotDecompressedSize caps the total bytes LoadSnapshot will
+// decompress out of an archive. Snapshots are routinely shipped alongside a
+// bug report by an external, untrusted reporter, so a crafted archive must
+// not be able to make LoadSnapshot exhaust memory by decompressing to an
+// arbitrary size.
+const maxSnapshotDecompressedSize = 256 << 20 // 256MiB
+
+// FileDigest describes one file captured in a Snapshot.
+type FileDigest struct {
+	Path   string `json:"path"`   // Path is the slash-separated path as given to fs.FS.
+	Size   int64  `json:"size"`   // Size is the file size in bytes.
+	SHA256 string `json:"sha256"` // SHA256 is the hex-encoded digest of the file contents.
+}
+
+// Snapshot is an immutable, content-addressed capture of every ".tm.hcl" and
+// ".tf" file read during a Terramate run. It implements FS, fs.StatFS and
+// fs.ReadDirFS (synthesizing directory entries from the captured paths) so
+// that tf.LoadModule, ParseModuleBlocks and the eval package can all be
+// pointed at a past run's input deterministically.
+type Snapshot struct {
+	Manifest []FileDigest
+	files    map[string][]byte
+}
+
+// NewRecorder wraps fsys so that every file successfully read through the
+// returned Recorder is captured, letting a Snapshot be built out of exactly
+// the files a run actually consumed rather than everything on disk.
+func NewRecorder(fsys FS) *Recorder {
+	return &Recorder{fsys: fsys, files: map[string][]byte{}}
+}
+
+// Recorder is an FS that transparently records every file it serves.
+type Recorder struct {
+	fsys FS
+
+	mu    sync.Mutex
+	files map[string][]byte
+}
+
+// Open implements FS, recording the file's contents each time it is opened;
+// a later read of the same name replaces the earlier capture.
+func (r *Recorder) Open(name string) (fs.File, error) {
+	f, err := r.fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := io.ReadAll(f)
+	closeErr := f.Close()
+	if err != nil {
+		return nil, err
+	}
+	if closeErr != nil {
+		return nil, closeErr
+	}
+
+	r.mu.Lock()
+	r.files[name] = data
+	r.mu.Unlock()
+
+	return &memFile{name: name, data: data}, nil
+}
+
+// Snapshot builds an immutable Snapshot of every file recorded so far.
+func (r *Recorder) Snapshot() *Snapshot {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	snap := &Snapshot{files: map[string][]byte{}}
+	for name, data := range r.files {
+		snap.files[name] = data
+		sum := sha256.Sum256(data)
+		snap.Manifest = append(snap.Manifest, FileDigest{
+			Path:   name,
+			Size:   int64(len(data)),
+			SHA256: hex.EncodeToString(sum[:]),
+		})
+	}
+
+	sort.Slice(snap.Manifest, func(i, j int) bool {
+		return snap.Manifest[i].Path < snap.Manifest[j].Path
+	})
+
+	return snap
+}
+
+// Open implements FS, serving files exactly as they were at capture time.
+func (s *Snapshot) Open(name string) (fs.File, error) {
+	data, ok := s.files[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return &memFile{name: name, data: data}, nil
+}
+
+// Stat implements fs.StatFS, synthesizing directory info for any path that
+// prefixes a captured file so that directory-walking consumers such as
+// tf.LoadModule can be pointed at a Snapshot.
+func (s *Snapshot) Stat(name string) (fs.FileInfo, error) {
+	return statFlatFS(s.files, name)
+}
+
+// ReadDir implements fs.ReadDirFS, listing the direct children of name as
+// captured in the snapshot.
+func (s *Snapshot) ReadDir(name string) ([]fs.DirEntry, error) {
+	return readDirFlatFS(s.files, name)
+}
+
+// WriteArchive serializes the snapshot as a gzip-compressed tar archive
+// containing the manifest and the raw bytes of every captured file, so that
+// it can be persisted to disk (or shipped alongside a bug report) and later
+// reloaded with LoadSnapshot.
+func (s *Snapshot) WriteArchive(w io.Writer) error {
+	manifest, err := json.MarshalIndent(s.Manifest, "", "  ")
+	if err != nil {
+		return errors.E(err, "marshaling snapshot manifest")
+	}
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+
+	if err := writeTarFile(tw, snapshotManifestName, manifest); err != nil {
+		return err
+	}
+
+	for _, digest := range s.Manifest {
+		if err := writeTarFile(tw, path.Join(snapshotFilesDir, digest.Path), s.files[digest.Path]); err != nil {
+			return err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return errors.E(err, "closing snapshot tar archive")
+	}
+	if err := gz.Close(); err != nil {
+		return errors.E(err, "closing snapshot gzip stream")
+	}
+	return nil
+}
+
+func writeTarFile(tw *tar.Writer, name string, data []byte) error {
+	hdr := &tar.Header{
+		Name:    name,
+		Mode:    0o644,
+		Size:    int64(len(data)),
+		ModTime: time.Unix(0, 0),
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return errors.E(err, "writing tar header for %q", name)
+	}
+	if _, err := tw.Write(data); err != nil {
+		return errors.E(err, "writing tar content for %q", name)
+	}
+	return nil
+}
+
+// LoadSnapshot reads back an archive written by Snapshot.WriteArchive,
+// verifying every file's contents against its recorded SHA256 digest.
+func LoadSnapshot(r io.Reader) (*Snapshot, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, errors.E(ErrSnapshotCorrupted, err, "opening gzip stream")
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+
+	var manifest []FileDigest
+	files := map[string][]byte{}
+
+	var totalSize int64
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.E(ErrSnapshotCorrupted, err, "reading tar entry")
+		}
+
+		totalSize += hdr.Size
+		if totalSize > maxSnapshotDecompressedSize {
+			return nil, errors.E(ErrSnapshotCorrupted,
+				"archive decompresses to more than %d bytes, refusing to load it", maxSnapshotDecompressedSize)
+		}
+
+		data, err := io.ReadAll(io.LimitReader(tr, maxSnapshotDecompressedSize+1))
+		if err != nil {
+			return nil, errors.E(ErrSnapshotCorrupted, err, "reading tar entry %q", hdr.Name)
+		}
+		if int64(len(data)) > maxSnapshotDecompressedSize {
+			return nil, errors.E(ErrSnapshotCorrupted,
+				"archive decompresses to more than %d bytes, refusing to load it", maxSnapshotDecompressedSize)
+		}
+
+		if hdr.Name == snapshotManifestName {
+			if err := json.Unmarshal(data, &manifest); err != nil {
+				return nil, errors.E(ErrSnapshotCorrupted, err, "decoding snapshot manifest")
+			}
+
+			var manifestSize int64
+			for _, digest := range manifest {
+				manifestSize += digest.Size
+			}
+			if manifestSize > maxSnapshotDecompressedSize {
+				return nil, errors.E(ErrSnapshotCorrupted,
+					"manifest declares %d bytes of files, more than the %d byte limit, refusing to load it",
+					manifestSize, maxSnapshotDecompressedSize)
+			}
+			continue
+		}
+
+		name := path.Clean(hdr.Name)
+		if rel, ok := cutPrefix(name, snapshotFilesDir+"/"); ok {
+			files[rel] = data
+		}
+	}
+
+	errs := errors.L()
+	for _, digest := range manifest {
+		data, ok := files[digest.Path]
+		if !ok {
+			errs.Append(errors.E(ErrSnapshotCorrupted, "missing file %q in archive", digest.Path))
+			continue
+		}
+		sum := sha256.Sum256(data)
+		if hex.EncodeToString(sum[:]) != digest.SHA256 {
+			errs.Append(errors.E(ErrSnapshotCorrupted, "digest mismatch for %q", digest.Path))
+		}
+	}
+	if err := errs.AsError(); err != nil {
+		return nil, err
+	}
+
+	return &Snapshot{Manifest: manifest, files: files}, nil
+}
+
+func cutPrefix(s, prefix string) (string, bool) {
+	if len(s) < len(prefix) || s[:len(prefix)] != prefix {
+		return s, false
+	}
+	return s[len(prefix):], true
+}
+
+// memFile is a read-only in-memory fs.File backing both Recorder and
+// Snapshot reads.
+type memFile struct {
+	name   string
+	data   []byte
+	reader *bytes.Reader
+}
+
+func (f *memFile) Stat() (fs.FileInfo, error) {
+	return memFileInfo{name: path.Base(f.name), size: int64(len(f.data))}, nil
+}
+
+func (f *memFile) Read(p []byte) (int, error) {
+	if f.reader == nil {
+		f.reader = bytes.NewReader(f.data)
+	}
+	return f.reader.Read(p)
+}
+
+func (f *memFile) Close() error { return nil }
+
+type memFileInfo struct {
+	name string
+	size int64
+	dir  bool
+}
+
+func (i memFileInfo) Name() string { return i.name }
+func (i memFileInfo) Size() int64  { return i.size }
+
+func (i memFileInfo) Mode() fs.FileMode {
+	if i.dir {
+		return fs.ModeDir | 0o555
+	}
+	return 0o444
+}
+
+func (i memFileInfo) ModTime() time.Time { return time.Unix(0, 0) }
+func (i memFileInfo) IsDir() bool        { return i.dir }
+func (i memFileInfo) Sys() any           { return nil }
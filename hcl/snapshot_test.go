@@ -0,0 +1,108 @@
+// Copyright 2022 Mineiros GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hcl_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"testing"
+
+	"github.com/madlambda/spells/assert"
+	"github.com/mineiros-io/terramate/hcl"
+	"github.com/mineiros-io/terramate/tf"
+)
+
+func TestSnapshotRoundTripBacksLoadModule(t *testing.T) {
+	rec := hcl.NewRecorder(hcl.MemFS{
+		"module/main.tf": []byte(`
+variable "name" {
+  type = string
+}
+`),
+	})
+
+	if _, err := hcl.ReadFile(rec, "module/main.tf"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := rec.Snapshot().WriteArchive(&buf); err != nil {
+		t.Fatalf("unexpected error writing archive: %v", err)
+	}
+
+	snap, err := hcl.LoadSnapshot(&buf)
+	if err != nil {
+		t.Fatalf("unexpected error loading snapshot: %v", err)
+	}
+
+	manifest, err := tf.LoadModule(snap, "module")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	assert.EqualInts(t, 1, len(manifest.Variables))
+	assert.EqualStrings(t, "name", manifest.Variables[0].Name)
+	assert.EqualStrings(t, "string", manifest.Variables[0].Type)
+}
+
+// writeSnapshotArchive builds a gzip-compressed tar archive with the same
+// layout Snapshot.WriteArchive produces, but from a caller-supplied manifest,
+// so the size guards in LoadSnapshot can be exercised independently of the
+// real writer. declaredSize is the tar header Size of the file entry;
+// tar.Writer fails unless it equals len(fileContent).
+func writeSnapshotArchive(t *testing.T, manifestJSON string, fileContent []byte, declaredSize int64) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+
+	writeEntry := func(name string, data []byte, size int64) {
+		t.Helper()
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: size}); err != nil {
+			t.Fatalf("writing tar header for %q: %v", name, err)
+		}
+		if _, err := tw.Write(data); err != nil {
+			t.Fatalf("writing tar content for %q: %v", name, err)
+		}
+	}
+
+	writeEntry("manifest.json", []byte(manifestJSON), int64(len(manifestJSON)))
+	writeEntry("files/module/main.tf", fileContent, declaredSize)
+
+	if err := tw.Close(); err != nil {
+		t.Fatalf("closing tar writer: %v", err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatalf("closing gzip writer: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestLoadSnapshotRejectsManifestOverDecompressionCap(t *testing.T) {
+	// The manifest alone declares a file larger than the decompression cap,
+	// so LoadSnapshot must refuse the archive even though the actual tar
+	// entry is tiny - a crafted manifest can't be used to smuggle a claim
+	// past the cap that a later consumer might trust.
+	manifestJSON := `[{"path":"module/main.tf","size":274877906945,"sha256":""}]`
+
+	archive := writeSnapshotArchive(t, manifestJSON, []byte("x"), 1)
+
+	_, err := hcl.LoadSnapshot(bytes.NewReader(archive))
+	if err == nil {
+		t.Fatal("expected an error, got nil")
+	}
+}
@@ -0,0 +1,618 @@
+// Copyright 2022 Mineiros GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tf
+
+import (
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	hhcl "github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclparse"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/mineiros-io/terramate/errors"
+	"github.com/mineiros-io/terramate/hcl"
+	"github.com/mineiros-io/terramate/hcl/ast"
+	"github.com/rs/zerolog/log"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// ErrManifestSchema indicates a root module contains a construct that
+// ModuleManifest cannot make sense of.
+const ErrManifestSchema errors.Kind = "terraform manifest schema error"
+
+type (
+	// ModuleManifest is a terraform-config-inspect-style description of
+	// every top-level construct declared by the .tf and .tf.json files of a
+	// root module. It is built by LoadModule.
+	ModuleManifest struct {
+		Path              string                      // Path is the directory the manifest was loaded from.
+		ModuleCalls       []ModuleCall                // ModuleCalls are the "module" blocks declared in the root module.
+		ManagedResources  []Resource                  // ManagedResources are the "resource" blocks.
+		DataResources     []Resource                  // DataResources are the "data" blocks.
+		Variables         []Variable                  // Variables are the "variable" blocks.
+		Outputs           []Output                    // Outputs are the "output" blocks.
+		ProviderConfigs   []ProviderConfig            // ProviderConfigs are the "provider" blocks.
+		RequiredProviders map[string]RequiredProvider // RequiredProviders is the merged "required_providers" map, keyed by local name.
+		RequiredVersion   []string                    // RequiredVersion is every "required_version" constraint found across "terraform" blocks.
+		Backend           *Backend                    // Backend is the "backend" block, if any "terraform" block declares one.
+		Cloud             *Cloud                      // Cloud is the "cloud" block, if any "terraform" block declares one.
+	}
+
+	// ModuleCall represents a "module" block.
+	ModuleCall struct {
+		Name        string
+		Source      string
+		Version     string
+		CountOrEach bool // CountOrEach tells if the call has a "count" or "for_each" attribute.
+		Range       hhcl.Range
+	}
+
+	// Resource represents a "resource" or "data" block.
+	Resource struct {
+		Type        string
+		Name        string
+		Provider    string // Provider is the resolved provider local name (from the "provider" attribute, or the type's implied prefix).
+		CountOrEach bool
+		Range       hhcl.Range
+	}
+
+	// Variable represents a "variable" block.
+	Variable struct {
+		Name        string
+		Type        string // Type is the raw source text of the "type" attribute expression, if present.
+		Default     cty.Value
+		HasDefault  bool
+		Sensitive   bool
+		Description string
+		Range       hhcl.Range
+	}
+
+	// Output represents an "output" block.
+	Output struct {
+		Name        string
+		Sensitive   bool
+		Description string
+		Range       hhcl.Range
+	}
+
+	// ProviderConfig represents a "provider" block.
+	ProviderConfig struct {
+		Name  string
+		Alias string
+		Range hhcl.Range
+	}
+
+	// RequiredProvider represents a single entry of a "required_providers"
+	// attribute inside a "terraform" block.
+	RequiredProvider struct {
+		LocalName string
+		Source    string
+		Version   string
+		Range     hhcl.Range
+	}
+
+	// Backend represents a "backend" block nested in a "terraform" block.
+	Backend struct {
+		Type  string
+		Range hhcl.Range
+	}
+
+	// Cloud represents a "cloud" block nested in a "terraform" block.
+	Cloud struct {
+		Range hhcl.Range
+	}
+)
+
+// LoadModule walks dir (non-recursively, as Terraform itself does for a
+// single root module) parsing every ".tf" and ".tf.json" file found and
+// aggregates them into a single ModuleManifest describing its contents.
+// File contents are read through fsys, so it can point at the real OS, an
+// in-memory fixture, or a loaded hcl.Snapshot.
+//
+// All diagnostics produced while parsing and decoding the module's files are
+// collected via errors.L() so that a single invocation reports every problem
+// found rather than just the first one.
+func LoadModule(fsys hcl.FS, dir string) (*ModuleManifest, error) {
+	logger := log.With().
+		Str("action", "LoadModule()").
+		Str("dir", dir).
+		Logger()
+
+	logger.Trace().Msg("Get path information.")
+
+	info, err := fs.Stat(fsys, dir)
+	if err != nil {
+		return nil, errors.E(err, "stat failed on %q", dir)
+	}
+	if !info.IsDir() {
+		return nil, errors.E(ErrManifestSchema, "%q is not a directory", dir)
+	}
+
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, errors.E(err, "reading directory %q", dir)
+	}
+
+	manifest := &ModuleManifest{
+		Path:              dir,
+		RequiredProviders: map[string]RequiredProvider{},
+	}
+
+	errs := errors.L()
+	p := hclparse.NewParser()
+
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+
+		name := entry.Name()
+		isJSON := strings.HasSuffix(name, ".tf.json")
+		if !isJSON && !strings.HasSuffix(name, ".tf") {
+			continue
+		}
+
+		path := filepath.Join(dir, name)
+
+		logger.Debug().Str("file", path).Msg("Parse HCL file")
+
+		src, err := hcl.ReadFile(fsys, path)
+		if err != nil {
+			errs.Append(errors.E(err, "reading %q", path))
+			continue
+		}
+
+		var (
+			f     *hhcl.File
+			diags hhcl.Diagnostics
+		)
+		if isJSON {
+			f, diags = p.ParseJSON(src, path)
+		} else {
+			f, diags = p.ParseHCL(src, path)
+		}
+		if diags.HasErrors() {
+			errs.Append(errors.E(ErrHCLSyntax, diags))
+			continue
+		}
+
+		body, ok := f.Body.(*hclsyntax.Body)
+		if !ok {
+			// .tf.json files don't expose a *hclsyntax.Body, so fall back to
+			// the generic hcl.Body content API.
+			errs.Append(decodeJSONBody(manifest, f.Body))
+			continue
+		}
+
+		for _, block := range body.Blocks {
+			errs.Append(mergeBlock(manifest, block, src))
+		}
+	}
+
+	sortManifest(manifest)
+
+	if err := errs.AsError(); err != nil {
+		return nil, err
+	}
+
+	return manifest, nil
+}
+
+func mergeBlock(manifest *ModuleManifest, block *hclsyntax.Block, src []byte) error {
+	switch block.Type {
+	case "module":
+		return addModuleCall(manifest, block)
+	case "resource":
+		return addResource(manifest, block, &manifest.ManagedResources)
+	case "data":
+		return addResource(manifest, block, &manifest.DataResources)
+	case "variable":
+		return addVariable(manifest, block, src)
+	case "output":
+		return addOutput(manifest, block)
+	case "provider":
+		return addProviderConfig(manifest, block)
+	case "terraform":
+		return addTerraformBlock(manifest, block)
+	default:
+		return nil
+	}
+}
+
+func addModuleCall(manifest *ModuleManifest, block *hclsyntax.Block) error {
+	if len(block.Labels) != 1 {
+		return errors.E(ErrManifestSchema, block.OpenBraceRange,
+			"\"module\" block must have 1 label")
+	}
+
+	source, _, err := findStringAttr(block, "source")
+	if err != nil {
+		return errors.E(ErrManifestSchema, err,
+			"looking for module.%q.source attribute", block.Labels[0])
+	}
+
+	version, _, err := findStringAttr(block, "version")
+	if err != nil {
+		return errors.E(ErrManifestSchema, err,
+			"looking for module.%q.version attribute", block.Labels[0])
+	}
+
+	manifest.ModuleCalls = append(manifest.ModuleCalls, ModuleCall{
+		Name:        block.Labels[0],
+		Source:      source,
+		Version:     version,
+		CountOrEach: hasAttr(block, "count") || hasAttr(block, "for_each"),
+		Range:       block.DefRange(),
+	})
+	return nil
+}
+
+func addResource(manifest *ModuleManifest, block *hclsyntax.Block, into *[]Resource) error {
+	if len(block.Labels) != 2 {
+		return errors.E(ErrManifestSchema, block.OpenBraceRange,
+			"%q block must have 2 labels", block.Type)
+	}
+
+	provider, _, err := findStringAttr(block, "provider")
+	if err != nil {
+		return errors.E(ErrManifestSchema, err,
+			"looking for %s.%s.provider attribute", block.Labels[0], block.Labels[1])
+	}
+	if provider == "" {
+		provider = impliedProvider(block.Labels[0])
+	}
+
+	*into = append(*into, Resource{
+		Type:        block.Labels[0],
+		Name:        block.Labels[1],
+		Provider:    provider,
+		CountOrEach: hasAttr(block, "count") || hasAttr(block, "for_each"),
+		Range:       block.DefRange(),
+	})
+	return nil
+}
+
+func addVariable(manifest *ModuleManifest, block *hclsyntax.Block, src []byte) error {
+	if len(block.Labels) != 1 {
+		return errors.E(ErrManifestSchema, block.OpenBraceRange,
+			"\"variable\" block must have 1 label")
+	}
+
+	v := Variable{Name: block.Labels[0], Range: block.DefRange()}
+
+	if attr, ok := ast.AsHCLAttributes(block.Body.Attributes)["type"]; ok {
+		v.Type = string(attr.Expr.Range().SliceBytes(src))
+	}
+
+	if attr, ok := ast.AsHCLAttributes(block.Body.Attributes)["default"]; ok {
+		val, diags := attr.Expr.Value(nil)
+		if diags.HasErrors() {
+			return errors.E(ErrManifestSchema, diags)
+		}
+		v.Default = val
+		v.HasDefault = true
+	}
+
+	sensitive, _, err := findBoolAttr(block, "sensitive")
+	if err != nil {
+		return errors.E(ErrManifestSchema, err, "looking for variable.%q.sensitive attribute", v.Name)
+	}
+	v.Sensitive = sensitive
+
+	desc, _, err := findStringAttr(block, "description")
+	if err != nil {
+		return errors.E(ErrManifestSchema, err, "looking for variable.%q.description attribute", v.Name)
+	}
+	v.Description = desc
+
+	manifest.Variables = append(manifest.Variables, v)
+	return nil
+}
+
+func addOutput(manifest *ModuleManifest, block *hclsyntax.Block) error {
+	if len(block.Labels) != 1 {
+		return errors.E(ErrManifestSchema, block.OpenBraceRange,
+			"\"output\" block must have 1 label")
+	}
+
+	sensitive, _, err := findBoolAttr(block, "sensitive")
+	if err != nil {
+		return errors.E(ErrManifestSchema, err, "looking for output.%q.sensitive attribute", block.Labels[0])
+	}
+
+	desc, _, err := findStringAttr(block, "description")
+	if err != nil {
+		return errors.E(ErrManifestSchema, err, "looking for output.%q.description attribute", block.Labels[0])
+	}
+
+	manifest.Outputs = append(manifest.Outputs, Output{
+		Name:        block.Labels[0],
+		Sensitive:   sensitive,
+		Description: desc,
+		Range:       block.DefRange(),
+	})
+	return nil
+}
+
+func addProviderConfig(manifest *ModuleManifest, block *hclsyntax.Block) error {
+	if len(block.Labels) != 1 {
+		return errors.E(ErrManifestSchema, block.OpenBraceRange,
+			"\"provider\" block must have 1 label")
+	}
+
+	alias, _, err := findStringAttr(block, "alias")
+	if err != nil {
+		return errors.E(ErrManifestSchema, err, "looking for provider.%q.alias attribute", block.Labels[0])
+	}
+
+	manifest.ProviderConfigs = append(manifest.ProviderConfigs, ProviderConfig{
+		Name:  block.Labels[0],
+		Alias: alias,
+		Range: block.DefRange(),
+	})
+	return nil
+}
+
+func addTerraformBlock(manifest *ModuleManifest, block *hclsyntax.Block) error {
+	errs := errors.L()
+
+	version, _, err := findStringAttr(block, "required_version")
+	if err != nil {
+		errs.Append(errors.E(ErrManifestSchema, err, "looking for terraform.required_version attribute"))
+	} else if version != "" {
+		manifest.RequiredVersion = append(manifest.RequiredVersion, version)
+	}
+
+	for _, nested := range block.Body.Blocks {
+		switch nested.Type {
+		case "required_providers":
+			for _, attr := range ast.SortRawAttributes(ast.AsHCLAttributes(nested.Body.Attributes)) {
+				rp, err := parseRequiredProvider(attr)
+				if err != nil {
+					errs.Append(errors.E(ErrManifestSchema, err,
+						"decoding required_providers.%s", attr.Name))
+					continue
+				}
+				manifest.RequiredProviders[attr.Name] = rp
+			}
+		case "backend":
+			if len(nested.Labels) != 1 {
+				errs.Append(errors.E(ErrManifestSchema, nested.OpenBraceRange,
+					"\"backend\" block must have 1 label"))
+				continue
+			}
+			manifest.Backend = &Backend{Type: nested.Labels[0], Range: nested.DefRange()}
+		case "cloud":
+			manifest.Cloud = &Cloud{Range: nested.DefRange()}
+		}
+	}
+
+	return errs.AsError()
+}
+
+func parseRequiredProvider(attr *hclsyntax.Attribute) (RequiredProvider, error) {
+	val, diags := attr.Expr.Value(nil)
+	if diags.HasErrors() {
+		return RequiredProvider{}, errors.E(diags)
+	}
+	return requiredProviderFromValue(attr.Name, attr.SrcRange, val)
+}
+
+// requiredProviderFromValue decodes a single required_providers entry from
+// its already-evaluated cty.Value, shared between the native HCL path
+// (parseRequiredProvider) and the JSON fallback path (decodeJSONTerraformBlock).
+func requiredProviderFromValue(localName string, rng hhcl.Range, val cty.Value) (RequiredProvider, error) {
+	rp := RequiredProvider{LocalName: localName, Range: rng}
+
+	switch {
+	case val.Type() == cty.String:
+		rp.Source = val.AsString()
+	case val.Type().IsObjectType():
+		m := val.AsValueMap()
+		if source, ok := m["source"]; ok && source.Type() == cty.String {
+			rp.Source = source.AsString()
+		}
+		if version, ok := m["version"]; ok && version.Type() == cty.String {
+			rp.Version = version.AsString()
+		}
+	default:
+		return RequiredProvider{}, errors.E(
+			"required_providers.%s must be a string or an object", localName)
+	}
+
+	return rp, nil
+}
+
+// impliedProvider returns the provider local name implied by a resource
+// type, ie. the part of the type before the first underscore.
+func impliedProvider(resourceType string) string {
+	if i := strings.IndexByte(resourceType, '_'); i > 0 {
+		return resourceType[:i]
+	}
+	return resourceType
+}
+
+func hasAttr(block *hclsyntax.Block, name string) bool {
+	_, ok := ast.AsHCLAttributes(block.Body.Attributes)[name]
+	return ok
+}
+
+func findBoolAttr(block *hclsyntax.Block, attrName string) (bool, bool, error) {
+	attrs := ast.AsHCLAttributes(block.Body.Attributes)
+	attr, ok := attrs[attrName]
+	if !ok {
+		return false, false, nil
+	}
+
+	val, diags := attr.Expr.Value(nil)
+	if diags.HasErrors() {
+		return false, false, errors.E(diags)
+	}
+	if val.Type() != cty.Bool {
+		return false, false, errors.E(
+			"attribute %q is not a bool", attr.Name, attr.Expr.Range(),
+		)
+	}
+	return val.True(), true, nil
+}
+
+// decodeJSONBody handles the ".tf.json" case, whose hcl.Body implementation
+// is not a *hclsyntax.Body and therefore cannot be walked the same way. For
+// module, resource, data, variable, output and provider blocks only the block
+// types and labels are recorded through the generic PartialContent API;
+// their attributes are not read. The "terraform" block is decoded in full by
+// decodeJSONTerraformBlock.
+func decodeJSONBody(manifest *ModuleManifest, body hhcl.Body) error {
+	content, _, diags := body.PartialContent(&hhcl.BodySchema{
+		Blocks: []hhcl.BlockHeaderSchema{
+			{Type: "module", LabelNames: []string{"name"}},
+			{Type: "resource", LabelNames: []string{"type", "name"}},
+			{Type: "data", LabelNames: []string{"type", "name"}},
+			{Type: "variable", LabelNames: []string{"name"}},
+			{Type: "output", LabelNames: []string{"name"}},
+			{Type: "provider", LabelNames: []string{"name"}},
+			{Type: "terraform"},
+		},
+	})
+	if diags.HasErrors() {
+		return errors.E(ErrHCLSyntax, diags)
+	}
+
+	errs := errors.L()
+
+	for _, block := range content.Blocks {
+		switch block.Type {
+		case "module":
+			manifest.ModuleCalls = append(manifest.ModuleCalls, ModuleCall{
+				Name: block.Labels[0], Range: block.DefRange,
+			})
+		case "resource":
+			manifest.ManagedResources = append(manifest.ManagedResources, Resource{
+				Type: block.Labels[0], Name: block.Labels[1], Range: block.DefRange,
+			})
+		case "data":
+			manifest.DataResources = append(manifest.DataResources, Resource{
+				Type: block.Labels[0], Name: block.Labels[1], Range: block.DefRange,
+			})
+		case "variable":
+			manifest.Variables = append(manifest.Variables, Variable{
+				Name: block.Labels[0], Range: block.DefRange,
+			})
+		case "output":
+			manifest.Outputs = append(manifest.Outputs, Output{
+				Name: block.Labels[0], Range: block.DefRange,
+			})
+		case "provider":
+			manifest.ProviderConfigs = append(manifest.ProviderConfigs, ProviderConfig{
+				Name: block.Labels[0], Range: block.DefRange,
+			})
+		case "terraform":
+			errs.Append(decodeJSONTerraformBlock(manifest, block.Body))
+		}
+	}
+
+	return errs.AsError()
+}
+
+// decodeJSONTerraformBlock handles the "terraform" block of a ".tf.json"
+// root module, the JSON counterpart of addTerraformBlock: it populates
+// RequiredVersion, RequiredProviders, Backend and Cloud the same way the
+// native HCL path does, just reached through the generic hcl.Body API
+// instead of *hclsyntax.Block.
+func decodeJSONTerraformBlock(manifest *ModuleManifest, body hhcl.Body) error {
+	content, _, diags := body.PartialContent(&hhcl.BodySchema{
+		Attributes: []hhcl.AttributeSchema{{Name: "required_version"}},
+		Blocks: []hhcl.BlockHeaderSchema{
+			{Type: "required_providers"},
+			{Type: "backend", LabelNames: []string{"type"}},
+			{Type: "cloud"},
+		},
+	})
+	if diags.HasErrors() {
+		return errors.E(ErrHCLSyntax, diags)
+	}
+
+	errs := errors.L()
+
+	if attr, ok := content.Attributes["required_version"]; ok {
+		val, diags := attr.Expr.Value(nil)
+		if diags.HasErrors() {
+			errs.Append(errors.E(ErrManifestSchema, diags))
+		} else if val.Type() == cty.String {
+			manifest.RequiredVersion = append(manifest.RequiredVersion, val.AsString())
+		}
+	}
+
+	for _, nested := range content.Blocks {
+		switch nested.Type {
+		case "required_providers":
+			attrs, diags := nested.Body.JustAttributes()
+			if diags.HasErrors() {
+				errs.Append(errors.E(ErrHCLSyntax, diags))
+				continue
+			}
+			for name, attr := range attrs {
+				val, diags := attr.Expr.Value(nil)
+				if diags.HasErrors() {
+					errs.Append(errors.E(ErrManifestSchema, diags))
+					continue
+				}
+				rp, err := requiredProviderFromValue(name, attr.Range, val)
+				if err != nil {
+					errs.Append(errors.E(ErrManifestSchema, err, "decoding required_providers.%s", name))
+					continue
+				}
+				manifest.RequiredProviders[name] = rp
+			}
+		case "backend":
+			manifest.Backend = &Backend{Type: nested.Labels[0], Range: nested.DefRange}
+		case "cloud":
+			manifest.Cloud = &Cloud{Range: nested.DefRange}
+		}
+	}
+
+	return errs.AsError()
+}
+
+// sortManifest orders every slice in manifest by name so that LoadModule is
+// deterministic regardless of the order fs.ReadDir or the HCL parser
+// returned blocks in.
+func sortManifest(manifest *ModuleManifest) {
+	sort.Slice(manifest.ModuleCalls, func(i, j int) bool {
+		return manifest.ModuleCalls[i].Name < manifest.ModuleCalls[j].Name
+	})
+	sortResources(manifest.ManagedResources)
+	sortResources(manifest.DataResources)
+	sort.Slice(manifest.Variables, func(i, j int) bool {
+		return manifest.Variables[i].Name < manifest.Variables[j].Name
+	})
+	sort.Slice(manifest.Outputs, func(i, j int) bool {
+		return manifest.Outputs[i].Name < manifest.Outputs[j].Name
+	})
+	sort.Slice(manifest.ProviderConfigs, func(i, j int) bool {
+		return manifest.ProviderConfigs[i].Name < manifest.ProviderConfigs[j].Name
+	})
+}
+
+func sortResources(resources []Resource) {
+	sort.Slice(resources, func(i, j int) bool {
+		if resources[i].Type != resources[j].Type {
+			return resources[i].Type < resources[j].Type
+		}
+		return resources[i].Name < resources[j].Name
+	})
+}
@@ -0,0 +1,180 @@
+// Copyright 2022 Mineiros GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tf_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/madlambda/spells/assert"
+	"github.com/mineiros-io/terramate/hcl"
+	"github.com/mineiros-io/terramate/tf"
+)
+
+func writeModuleFile(t *testing.T, dir, name, content string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+		t.Fatalf("writing fixture %q: %v", name, err)
+	}
+}
+
+func TestLoadModuleRequiredProvidersStringForm(t *testing.T) {
+	dir := t.TempDir()
+	writeModuleFile(t, dir, "main.tf", `
+terraform {
+  required_providers {
+    aws = "hashicorp/aws"
+  }
+}
+`)
+
+	manifest, err := tf.LoadModule(hcl.OSFS{}, dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	rp, ok := manifest.RequiredProviders["aws"]
+	assert.IsTrue(t, ok)
+	assert.EqualStrings(t, "hashicorp/aws", rp.Source)
+	assert.EqualStrings(t, "", rp.Version)
+}
+
+func TestLoadModuleRequiredProvidersObjectForm(t *testing.T) {
+	dir := t.TempDir()
+	writeModuleFile(t, dir, "main.tf", `
+terraform {
+  required_providers {
+    aws = {
+      source  = "hashicorp/aws"
+      version = ">= 4.0"
+    }
+  }
+}
+`)
+
+	manifest, err := tf.LoadModule(hcl.OSFS{}, dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	rp, ok := manifest.RequiredProviders["aws"]
+	assert.IsTrue(t, ok)
+	assert.EqualStrings(t, "hashicorp/aws", rp.Source)
+	assert.EqualStrings(t, ">= 4.0", rp.Version)
+}
+
+func TestLoadModuleRequiredProvidersInvalidType(t *testing.T) {
+	dir := t.TempDir()
+	writeModuleFile(t, dir, "main.tf", `
+terraform {
+  required_providers {
+    aws = 1
+  }
+}
+`)
+
+	_, err := tf.LoadModule(hcl.OSFS{}, dir)
+	if err == nil {
+		t.Fatal("expected an error, got nil")
+	}
+}
+
+func TestLoadModuleVariableAttributes(t *testing.T) {
+	dir := t.TempDir()
+	writeModuleFile(t, dir, "main.tf", `
+variable "name" {
+  type        = string
+  default     = "example"
+  sensitive   = true
+  description = "the name"
+}
+`)
+
+	manifest, err := tf.LoadModule(hcl.OSFS{}, dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	assert.EqualInts(t, 1, len(manifest.Variables))
+	v := manifest.Variables[0]
+	assert.EqualStrings(t, "name", v.Name)
+	assert.EqualStrings(t, "string", v.Type)
+	assert.IsTrue(t, v.HasDefault)
+	assert.IsTrue(t, v.Sensitive)
+	assert.EqualStrings(t, "the name", v.Description)
+}
+
+func TestLoadModuleJSONFallbackTerraformBlock(t *testing.T) {
+	dir := t.TempDir()
+	writeModuleFile(t, dir, "main.tf.json", `{
+  "terraform": {
+    "required_version": ">= 1.0",
+    "required_providers": {
+      "aws": {
+        "source":  "hashicorp/aws",
+        "version": ">= 4.0"
+      }
+    },
+    "backend": {
+      "s3": {}
+    }
+  }
+}`)
+
+	manifest, err := tf.LoadModule(hcl.OSFS{}, dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	assert.EqualInts(t, 1, len(manifest.RequiredVersion))
+	assert.EqualStrings(t, ">= 1.0", manifest.RequiredVersion[0])
+
+	rp, ok := manifest.RequiredProviders["aws"]
+	assert.IsTrue(t, ok)
+	assert.EqualStrings(t, "hashicorp/aws", rp.Source)
+	assert.EqualStrings(t, ">= 4.0", rp.Version)
+
+	if manifest.Backend == nil {
+		t.Fatal("expected a Backend, got nil")
+	}
+	assert.EqualStrings(t, "s3", manifest.Backend.Type)
+}
+
+func TestLoadModuleJSONFallback(t *testing.T) {
+	dir := t.TempDir()
+	writeModuleFile(t, dir, "main.tf.json", `{
+  "resource": {
+    "aws_instance": {
+      "example": {}
+    }
+  },
+  "variable": {
+    "name": {}
+  }
+}`)
+
+	manifest, err := tf.LoadModule(hcl.OSFS{}, dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	assert.EqualInts(t, 1, len(manifest.ManagedResources))
+	assert.EqualStrings(t, "aws_instance", manifest.ManagedResources[0].Type)
+	assert.EqualStrings(t, "example", manifest.ManagedResources[0].Name)
+
+	assert.EqualInts(t, 1, len(manifest.Variables))
+	assert.EqualStrings(t, "name", manifest.Variables[0].Name)
+}
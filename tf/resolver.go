@@ -0,0 +1,387 @@
+// Copyright 2022 Mineiros GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tf
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/mineiros-io/terramate/errors"
+	"github.com/mineiros-io/terramate/hcl"
+	"github.com/rs/zerolog/log"
+)
+
+// SourceKind classifies a ModuleBlock.Source.
+type SourceKind int
+
+// Supported module source kinds, in the same terms Terraform itself uses to
+// describe them.
+// See: https://www.terraform.io/language/modules/sources
+const (
+	SourceLocal SourceKind = iota
+	SourceRegistry
+	SourceGit
+	SourceHTTP
+	SourceS3
+	SourceGCS
+	SourceMercurial
+	SourceGitHub
+)
+
+// Errors returned while resolving module sources.
+const (
+	ErrSourceNotRecognized errors.Kind = "module source not recognized"
+	ErrModuleNotCached     errors.Kind = "module not cached and resolver is offline"
+	ErrRegistryDownload    errors.Kind = "terraform registry download handshake failed"
+)
+
+// registryModuleRe matches the "NAMESPACE/NAME/PROVIDER" shape of a
+// Terraform Registry module source, with an optional "HOST/" prefix for
+// private registries.
+var registryModuleRe = regexp.MustCompile(
+	`^([0-9a-zA-Z-][0-9a-zA-Z-.]*/)?([0-9a-zA-Z-_]+)/([0-9a-zA-Z-_]+)/([0-9a-zA-Z-_]+)$`)
+
+// Source is a classified module source.
+type Source struct {
+	Kind SourceKind
+	Raw  string // Raw is the original, unparsed source string.
+
+	// Registry-only fields.
+	Host      string
+	Namespace string
+	Name      string
+	Provider  string
+}
+
+// ClassifySource inspects a ModuleBlock.Source and determines which kind of
+// source it is, mirroring the rules documented at
+// https://www.terraform.io/language/modules/sources.
+func ClassifySource(source string) (Source, error) {
+	switch {
+	case source == "":
+		return Source{}, errors.E(ErrSourceNotRecognized, "empty module source")
+	case isLocalSource(source):
+		return Source{Kind: SourceLocal, Raw: source}, nil
+	case strings.HasPrefix(source, "git::") || strings.HasPrefix(source, "git@") ||
+		strings.HasSuffix(strings.SplitN(source, "?", 2)[0], ".git"):
+		return Source{Kind: SourceGit, Raw: source}, nil
+	case strings.HasPrefix(source, "github.com/"):
+		return Source{Kind: SourceGitHub, Raw: source}, nil
+	case strings.HasPrefix(source, "hg::"):
+		return Source{Kind: SourceMercurial, Raw: source}, nil
+	case strings.HasPrefix(source, "s3::"):
+		return Source{Kind: SourceS3, Raw: source}, nil
+	case strings.HasPrefix(source, "gcs::"):
+		return Source{Kind: SourceGCS, Raw: source}, nil
+	case strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://"):
+		return Source{Kind: SourceHTTP, Raw: source}, nil
+	default:
+		if m := registryModuleRe.FindStringSubmatch(source); m != nil {
+			host := strings.TrimSuffix(m[1], "/")
+			return Source{
+				Kind:      SourceRegistry,
+				Raw:       source,
+				Host:      host,
+				Namespace: m[2],
+				Name:      m[3],
+				Provider:  m[4],
+			}, nil
+		}
+		return Source{}, errors.E(ErrSourceNotRecognized, "%q is not a recognized module source", source)
+	}
+}
+
+func isLocalSource(source string) bool {
+	return (len(source) >= 2 && source[0:2] == "./") ||
+		(len(source) >= 3 && source[0:3] == "../")
+}
+
+// Downloader fetches a non-local module Source at the given version into
+// dst, which is guaranteed to exist and be empty. Implementations are
+// pluggable so tests can stub network and VCS access.
+type Downloader interface {
+	Download(dst string, source Source, version string) error
+}
+
+// Resolver resolves ModuleBlock sources to a location on the host
+// filesystem, downloading and caching non-local sources as needed.
+type Resolver struct {
+	// CacheDir is the root of the content-addressable module cache, eg.
+	// ".terramate/modules".
+	CacheDir string
+
+	// Downloader performs the actual fetch of non-local, non-cached
+	// sources. Defaults to goGetterDownloader{} if nil.
+	Downloader Downloader
+
+	// Offline, when true, makes Resolve fail with ErrModuleNotCached
+	// instead of downloading any source that isn't already in CacheDir.
+	Offline bool
+
+	// RegistryBaseURL overrides the default Terraform Registry host, for
+	// private registries or tests. Defaults to "https://registry.terraform.io".
+	RegistryBaseURL string
+}
+
+// NewResolver creates a Resolver that caches downloaded modules under
+// cacheDir.
+func NewResolver(cacheDir string) *Resolver {
+	return &Resolver{
+		CacheDir:        cacheDir,
+		Downloader:      goGetterDownloader{},
+		RegistryBaseURL: "https://registry.terraform.io",
+	}
+}
+
+// Resolve classifies block.Source and returns the host path where its
+// contents can be read from: baseDir joined with the source for local
+// sources, or a directory under CacheDir for the rest, downloading and
+// caching it first if necessary. The path is absolute only when baseDir or
+// CacheDir is.
+func (r *Resolver) Resolve(baseDir string, block ModuleBlock) (string, error) {
+	logger := log.With().
+		Str("action", "Resolver.Resolve()").
+		Str("source", block.Source).
+		Logger()
+
+	src, err := ClassifySource(block.Source)
+	if err != nil {
+		return "", err
+	}
+
+	if src.Kind == SourceLocal {
+		return filepath.Join(baseDir, block.Source), nil
+	}
+
+	cacheKey := cacheKeyFor(block.Source, block.Version)
+	dst := filepath.Join(r.CacheDir, cacheKey)
+
+	if _, err := os.Stat(dst); err == nil {
+		logger.Debug().Str("cache", dst).Msg("module already cached")
+		return dst, nil
+	}
+
+	if r.Offline {
+		return "", errors.E(ErrModuleNotCached,
+			"source %q is not cached at %q", block.Source, dst)
+	}
+
+	if src.Kind == SourceRegistry {
+		downloadSource, err := r.registryDownloadSource(src, block.Version)
+		if err != nil {
+			return "", err
+		}
+		src = downloadSource
+	}
+
+	logger.Debug().Str("cache", dst).Msg("downloading module")
+
+	if err := os.MkdirAll(dst, 0o755); err != nil {
+		return "", errors.E(err, "creating module cache dir %q", dst)
+	}
+
+	downloader := r.Downloader
+	if downloader == nil {
+		downloader = goGetterDownloader{}
+	}
+	if err := downloader.Download(dst, src, block.Version); err != nil {
+		_ = os.RemoveAll(dst)
+		return "", errors.E(err, "downloading %q", block.Source)
+	}
+
+	return dst, nil
+}
+
+// cacheKeyFor derives the content-addressable cache directory name for a
+// module source and version, as sha256(source@version) hex-encoded.
+func cacheKeyFor(source, version string) string {
+	sum := sha256.Sum256([]byte(source + "@" + version))
+	return hex.EncodeToString(sum[:])
+}
+
+// registryDownloadSource performs the standard Terraform Registry download
+// handshake: GET /v1/modules/<ns>/<name>/<provider>/<version>/download and
+// follow the X-Terraform-Get response header, which itself is a go-getter
+// style source string (eg. "git::https://...") that gets re-classified.
+func (r *Resolver) registryDownloadSource(src Source, version string) (Source, error) {
+	host := src.Host
+	if host == "" {
+		base := r.RegistryBaseURL
+		if base == "" {
+			base = "https://registry.terraform.io"
+		}
+		host = strings.TrimPrefix(base, "https://")
+		host = strings.TrimPrefix(host, "http://")
+	}
+
+	url := fmt.Sprintf("https://%s/v1/modules/%s/%s/%s/%s/download",
+		host, src.Namespace, src.Name, src.Provider, version)
+
+	// A GET is used instead of a HEAD because the Registry protocol isn't
+	// guaranteed to answer HEAD the same way it answers GET: some registries
+	// return 404/405 on HEAD for a path that GETs fine. The body itself isn't
+	// needed, only the X-Terraform-Get header, so it's discarded.
+	resp, err := http.Get(url)
+	if err != nil {
+		return Source{}, errors.E(ErrRegistryDownload, err, "requesting %q", url)
+	}
+	defer resp.Body.Close()
+	defer func() { _, _ = io.Copy(io.Discard, resp.Body) }()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		return Source{}, errors.E(ErrRegistryDownload,
+			"unexpected status %d from %q", resp.StatusCode, url)
+	}
+
+	location := resp.Header.Get("X-Terraform-Get")
+	if location == "" {
+		return Source{}, errors.E(ErrRegistryDownload,
+			"no X-Terraform-Get header in response from %q", url)
+	}
+
+	return ClassifySource(location)
+}
+
+// goGetterDownloader is the default Downloader, implementing a small
+// go-getter style detector chain over the source kinds ClassifySource
+// recognizes. It shells out to the relevant tool (git, hg) or performs a
+// plain HTTP(S)/cloud-storage GET, mirroring how Terraform itself fetches
+// remote modules.
+type goGetterDownloader struct{}
+
+// Download is implemented per-source-kind in resolver_download.go so that
+// each detector can be tested and evolved independently.
+func (d goGetterDownloader) Download(dst string, source Source, version string) error {
+	switch source.Kind {
+	case SourceGit, SourceGitHub:
+		return downloadGit(dst, source, version)
+	case SourceMercurial:
+		return downloadMercurial(dst, source, version)
+	case SourceHTTP, SourceS3, SourceGCS:
+		return downloadHTTP(dst, source, version)
+	default:
+		return errors.E(ErrSourceNotRecognized, "no downloader for source kind %v", source.Kind)
+	}
+}
+
+// ErrModuleCycle is returned by ResolveTree when a module, directly or
+// transitively, references one of its own ancestors.
+const ErrModuleCycle errors.Kind = "module cycle detected"
+
+// ResolveTree recursively resolves every module reachable from the root
+// module at rootDir, populating Module.Modules as it descends. stackRoot and
+// projectRoot, when non-empty, are used to compute each Module's
+// StackRelPath and RelPath with filepath.Rel. Module files are read through
+// fsys.
+//
+// The directories visited along the current recursion path are tracked so a
+// module that loops back to one of its own ancestors (directly, or through
+// another module in between) is reported as ErrModuleCycle instead of
+// recursing until the stack overflows.
+func (r *Resolver) ResolveTree(fsys hcl.FS, rootDir, stackRoot, projectRoot string) (*Module, error) {
+	return r.resolveTree(fsys, rootDir, stackRoot, projectRoot, map[string]bool{})
+}
+
+func (r *Resolver) resolveTree(fsys hcl.FS, rootDir, stackRoot, projectRoot string, ancestors map[string]bool) (*Module, error) {
+	key := rootDir
+	if abs, err := filepath.Abs(rootDir); err == nil {
+		key = abs
+	}
+
+	if ancestors[key] {
+		return nil, errors.E(ErrModuleCycle, "module at %q is already an ancestor of itself in this resolution path", rootDir)
+	}
+	ancestors[key] = true
+	defer delete(ancestors, key)
+
+	mod := &Module{HostPath: rootDir}
+	if stackRoot != "" {
+		if rel, err := filepath.Rel(stackRoot, rootDir); err == nil {
+			mod.StackRelPath = rel
+		}
+	}
+	if projectRoot != "" {
+		if rel, err := filepath.Rel(projectRoot, rootDir); err == nil {
+			mod.RelPath = rel
+		}
+	}
+
+	blocks, err := parseModuleBlocksInDir(fsys, rootDir)
+	if err != nil {
+		return nil, err
+	}
+
+	errs := errors.L()
+	for _, block := range blocks {
+		childDir, err := r.Resolve(rootDir, block)
+		if err != nil {
+			errs.Append(err)
+			continue
+		}
+
+		child, err := r.resolveTree(fsys, childDir, stackRoot, projectRoot, ancestors)
+		if err != nil {
+			errs.Append(err)
+			continue
+		}
+
+		mod.Modules = append(mod.Modules, *child)
+	}
+
+	if err := errs.AsError(); err != nil {
+		return nil, err
+	}
+
+	return mod, nil
+}
+
+// parseModuleBlocksInDir aggregates the ModuleBlock entries of every ".tf"
+// file directly inside dir, in the spirit of LoadModule but scoped to just
+// module calls since that's all the resolver needs.
+func parseModuleBlocksInDir(fsys hcl.FS, dir string) ([]ModuleBlock, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, errors.E(err, "reading directory %q", dir)
+	}
+
+	errs := errors.L()
+	var blocks []ModuleBlock
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".tf") {
+			continue
+		}
+
+		fileBlocks, err := ParseModuleBlocks(fsys, filepath.Join(dir, entry.Name()))
+		if err != nil {
+			errs.Append(err)
+			continue
+		}
+		blocks = append(blocks, fileBlocks...)
+	}
+
+	if err := errs.AsError(); err != nil {
+		return nil, err
+	}
+
+	return blocks, nil
+}
@@ -0,0 +1,143 @@
+// Copyright 2022 Mineiros GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tf
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/mineiros-io/terramate/errors"
+)
+
+// downloadGit clones a git:: or github.com/ source into dst at the ref
+// given by a "?ref=" query in the source or, failing that, by version; with
+// neither, the repository's default branch is cloned.
+func downloadGit(dst string, source Source, version string) error {
+	url, ref := splitGitSourceRef(source.Raw)
+	if source.Kind == SourceGitHub {
+		// The github.com/ shorthand has no scheme; clone it over HTTPS.
+		url = "https://" + url
+	}
+	if ref == "" {
+		ref = version
+	}
+	if err := rejectFlagLikeArg(url); err != nil {
+		return err
+	}
+
+	args := []string{"clone", "--depth", "1"}
+	if ref != "" {
+		args = append(args, "--branch", ref)
+	}
+	args = append(args, "--", url, dst)
+
+	cmd := exec.Command("git", args...)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return errors.E(err, "git clone failed: %s", string(out))
+	}
+	return nil
+}
+
+// downloadMercurial clones an hg:: source into dst at the revision given by
+// version.
+func downloadMercurial(dst string, source Source, version string) error {
+	url := strings.TrimPrefix(source.Raw, "hg::")
+	if err := rejectFlagLikeArg(url); err != nil {
+		return err
+	}
+
+	args := []string{"clone"}
+	if version != "" {
+		args = append(args, "--updaterev", version)
+	}
+	args = append(args, "--", url, dst)
+
+	cmd := exec.Command("hg", args...)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return errors.E(err, "hg clone failed: %s", string(out))
+	}
+	return nil
+}
+
+// rejectFlagLikeArg rejects a module source that would be interpreted as a
+// command-line option instead of a positional URL by git/hg, even with a
+// "--" end-of-options marker protecting it (eg. a bare "-" reads from
+// stdin for some subcommands). Module sources come straight out of
+// untrusted ".tf" files, so this is a defense in depth measure rather than
+// the only one.
+func rejectFlagLikeArg(arg string) error {
+	if strings.HasPrefix(arg, "-") {
+		return errors.E("module source %q looks like a command-line option, refusing to pass it to the VCS client", arg)
+	}
+	return nil
+}
+
+// downloadHTTP performs a plain GET of source.Raw (with its go-getter
+// detector prefix, if any, stripped) and writes the response body into dst
+// as a single file named after the URL's last path element. Archive
+// responses (zip, tar.gz) are stored as-is and are not extracted.
+func downloadHTTP(dst string, source Source, version string) error {
+	url := source.Raw
+	for _, prefix := range []string{"s3::", "gcs::"} {
+		url = strings.TrimPrefix(url, prefix)
+	}
+
+	resp, err := http.Get(url)
+	if err != nil {
+		return errors.E(err, "fetching %q", url)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return errors.E("unexpected status %d fetching %q", resp.StatusCode, url)
+	}
+
+	f, err := os.Create(filepath.Join(dst, filepath.Base(url)))
+	if err != nil {
+		return errors.E(err, "creating destination file in %q", dst)
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(f, resp.Body); err != nil {
+		return errors.E(err, "writing downloaded content to %q", dst)
+	}
+
+	return nil
+}
+
+// splitGitSourceRef strips the "git::" detector prefix and a trailing
+// "?ref=<ref>" query string from a git module source, returning the bare
+// clone URL and ref separately.
+func splitGitSourceRef(raw string) (url string, ref string) {
+	url = strings.TrimPrefix(raw, "git::")
+
+	parts := strings.SplitN(url, "?", 2)
+	url = parts[0]
+	if len(parts) == 2 {
+		for _, kv := range strings.Split(parts[1], "&") {
+			if strings.HasPrefix(kv, "ref=") {
+				ref = strings.TrimPrefix(kv, "ref=")
+			}
+		}
+	}
+
+	return url, ref
+}
@@ -0,0 +1,274 @@
+// Copyright 2022 Mineiros GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tf_test
+
+import (
+	"testing"
+
+	"github.com/madlambda/spells/assert"
+	"github.com/mineiros-io/terramate/hcl"
+	"github.com/mineiros-io/terramate/tf"
+)
+
+func TestClassifySource(t *testing.T) {
+	type want struct {
+		kind      tf.SourceKind
+		host      string
+		namespace string
+		name      string
+		provider  string
+	}
+
+	tcases := []struct {
+		name   string
+		source string
+		want   want
+		isErr  bool
+	}{
+		{
+			name:   "empty source",
+			source: "",
+			isErr:  true,
+		},
+		{
+			name:   "local relative path",
+			source: "./modules/vpc",
+			want:   want{kind: tf.SourceLocal},
+		},
+		{
+			name:   "local parent-relative path",
+			source: "../modules/vpc",
+			want:   want{kind: tf.SourceLocal},
+		},
+		{
+			name:   "git detector prefix",
+			source: "git::https://example.com/vpc.git",
+			want:   want{kind: tf.SourceGit},
+		},
+		{
+			name:   "bare git ssh",
+			source: "git@github.com:org/repo.git",
+			want:   want{kind: tf.SourceGit},
+		},
+		{
+			name:   "dot-git suffix without detector",
+			source: "https://example.com/vpc.git",
+			want:   want{kind: tf.SourceGit},
+		},
+		{
+			name:   "github.com shorthand",
+			source: "github.com/org/repo",
+			want:   want{kind: tf.SourceGitHub},
+		},
+		{
+			name:   "mercurial detector prefix",
+			source: "hg::https://example.com/vpc",
+			want:   want{kind: tf.SourceMercurial},
+		},
+		{
+			name:   "s3 detector prefix",
+			source: "s3::https://s3.amazonaws.com/bucket/vpc.zip",
+			want:   want{kind: tf.SourceS3},
+		},
+		{
+			name:   "gcs detector prefix",
+			source: "gcs::https://www.googleapis.com/storage/v1/bucket/vpc.zip",
+			want:   want{kind: tf.SourceGCS},
+		},
+		{
+			name:   "plain https url",
+			source: "https://example.com/vpc.zip",
+			want:   want{kind: tf.SourceHTTP},
+		},
+		{
+			name:   "public registry module",
+			source: "hashicorp/consul/aws",
+			want:   want{kind: tf.SourceRegistry, namespace: "hashicorp", name: "consul", provider: "aws"},
+		},
+		{
+			name:   "private registry module with host",
+			source: "registry.example.com/hashicorp/consul/aws",
+			want: want{
+				kind: tf.SourceRegistry, host: "registry.example.com",
+				namespace: "hashicorp", name: "consul", provider: "aws",
+			},
+		},
+		{
+			name:   "not recognized",
+			source: "not a valid source!!",
+			isErr:  true,
+		},
+	}
+
+	for _, tc := range tcases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := tf.ClassifySource(tc.source)
+			if tc.isErr {
+				if err == nil {
+					t.Fatal("expected an error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if got.Kind != tc.want.kind {
+				t.Fatalf("got kind %v want %v", got.Kind, tc.want.kind)
+			}
+			assert.EqualStrings(t, tc.want.host, got.Host)
+			assert.EqualStrings(t, tc.want.namespace, got.Namespace)
+			assert.EqualStrings(t, tc.want.name, got.Name)
+			assert.EqualStrings(t, tc.want.provider, got.Provider)
+			assert.EqualStrings(t, tc.source, got.Raw)
+		})
+	}
+}
+
+// stubDownloader is a tf.Downloader that records the calls made to it
+// instead of touching the network, exercising the seam Resolver.Downloader
+// exists for.
+type stubDownloader struct {
+	calls int
+	dst   string
+	src   tf.Source
+}
+
+func (d *stubDownloader) Download(dst string, source tf.Source, version string) error {
+	d.calls++
+	d.dst = dst
+	d.src = source
+	return nil
+}
+
+func TestResolverResolveUsesConfiguredDownloader(t *testing.T) {
+	downloader := &stubDownloader{}
+	r := &tf.Resolver{
+		CacheDir:   t.TempDir(),
+		Downloader: downloader,
+	}
+
+	dst, err := r.Resolve("/root", tf.ModuleBlock{Source: "https://example.com/vpc.zip"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	assert.EqualInts(t, 1, downloader.calls)
+	assert.EqualStrings(t, dst, downloader.dst)
+	if downloader.src.Kind != tf.SourceHTTP {
+		t.Fatalf("got source kind %v want %v", downloader.src.Kind, tf.SourceHTTP)
+	}
+
+	// Resolving the same source again must hit the cache instead of calling
+	// the Downloader a second time.
+	dst2, err := r.Resolve("/root", tf.ModuleBlock{Source: "https://example.com/vpc.zip"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assert.EqualStrings(t, dst, dst2)
+	assert.EqualInts(t, 1, downloader.calls)
+}
+
+func TestResolverResolveOfflineFailsOnUncachedSource(t *testing.T) {
+	downloader := &stubDownloader{}
+	r := &tf.Resolver{
+		CacheDir:   t.TempDir(),
+		Downloader: downloader,
+		Offline:    true,
+	}
+
+	_, err := r.Resolve("/root", tf.ModuleBlock{Source: "https://example.com/vpc.zip"})
+	if err == nil {
+		t.Fatal("expected an error, got nil")
+	}
+	assert.EqualInts(t, 0, downloader.calls)
+}
+
+func TestResolverResolveLocalSourceDoesNotCallDownloader(t *testing.T) {
+	downloader := &stubDownloader{}
+	r := &tf.Resolver{
+		CacheDir:   t.TempDir(),
+		Downloader: downloader,
+	}
+
+	dst, err := r.Resolve("/root", tf.ModuleBlock{Source: "./modules/vpc"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	assert.EqualStrings(t, "/root/modules/vpc", dst)
+	assert.EqualInts(t, 0, downloader.calls)
+}
+
+func TestResolverResolveTreeDetectsDirectCycle(t *testing.T) {
+	fsys := hcl.MemFS{
+		"module/main.tf": []byte(`
+module "self" {
+  source = "./"
+}
+`),
+	}
+
+	r := &tf.Resolver{CacheDir: t.TempDir()}
+
+	_, err := r.ResolveTree(fsys, "module", "", "")
+	if err == nil {
+		t.Fatal("expected an error, got nil")
+	}
+}
+
+func TestResolverResolveTreeDetectsTransitiveCycle(t *testing.T) {
+	fsys := hcl.MemFS{
+		"root/main.tf": []byte(`
+module "child" {
+  source = "../child"
+}
+`),
+		"child/main.tf": []byte(`
+module "back" {
+  source = "../root"
+}
+`),
+	}
+
+	r := &tf.Resolver{CacheDir: t.TempDir()}
+
+	_, err := r.ResolveTree(fsys, "root", "", "")
+	if err == nil {
+		t.Fatal("expected an error, got nil")
+	}
+}
+
+func TestResolverResolveTreeResolvesNonCyclicModules(t *testing.T) {
+	fsys := hcl.MemFS{
+		"root/main.tf": []byte(`
+module "child" {
+  source = "./child"
+}
+`),
+		"root/child/main.tf": []byte(``),
+	}
+
+	r := &tf.Resolver{CacheDir: t.TempDir()}
+
+	mod, err := r.ResolveTree(fsys, "root", "", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	assert.EqualInts(t, 1, len(mod.Modules))
+	assert.EqualStrings(t, "root/child", mod.Modules[0].HostPath)
+}
@@ -15,12 +15,11 @@
 package tf
 
 import (
-	"os"
-
-	"github.com/hashicorp/hcl/v2"
+	hhcl "github.com/hashicorp/hcl/v2"
 	"github.com/hashicorp/hcl/v2/hclparse"
 	"github.com/hashicorp/hcl/v2/hclsyntax"
 	"github.com/mineiros-io/terramate/errors"
+	"github.com/mineiros-io/terramate/hcl"
 	"github.com/mineiros-io/terramate/hcl/ast"
 	"github.com/rs/zerolog/log"
 	"github.com/zclconf/go-cty/cty"
@@ -29,7 +28,8 @@ import (
 // ModuleBlock represents a terraform module block.
 // Note that only the fields relevant for terramate are declared here.
 type ModuleBlock struct {
-	Source string // Source is the module source path (eg.: directory, git path, etc).
+	Source  string // Source is the module source path (eg.: directory, git path, etc).
+	Version string // Version is the module version constraint, if the block declares one.
 }
 
 // Module represents a terraform module on disk
@@ -54,17 +54,19 @@ func (m ModuleBlock) IsLocal() bool {
 }
 
 // ParseModuleBlocks parses blocks of type "module" containing a single label.
-func ParseModuleBlocks(path string) ([]ModuleBlock, error) {
+// File contents are read through fsys, so it can point at the real OS, an
+// in-memory fixture, or a loaded hcl.Snapshot.
+func ParseModuleBlocks(fsys hcl.FS, path string) ([]ModuleBlock, error) {
 	logger := log.With().
 		Str("action", "ParseModuleBlocks()").
 		Str("path", path).
 		Logger()
 
-	logger.Trace().Msg("Get path information.")
+	logger.Trace().Msg("Read file contents.")
 
-	_, err := os.Stat(path)
+	src, err := hcl.ReadFile(fsys, path)
 	if err != nil {
-		return nil, errors.E(err, "stat failed on %q", path)
+		return nil, errors.E(err, "reading %q", path)
 	}
 
 	logger.Trace().Msg("Create new parser")
@@ -73,7 +75,7 @@ func ParseModuleBlocks(path string) ([]ModuleBlock, error) {
 
 	logger.Debug().Msg("Parse HCL file")
 
-	f, diags := p.ParseHCLFile(path)
+	f, diags := p.ParseHCL(src, path)
 	if diags.HasErrors() {
 		return nil, errors.E(ErrHCLSyntax, diags)
 	}
@@ -106,11 +108,18 @@ func ParseModuleBlocks(path string) ([]ModuleBlock, error) {
 		}
 		if !ok {
 			errs.Append(errors.E(ErrTerraformSchema,
-				hcl.RangeBetween(block.OpenBraceRange, block.CloseBraceRange),
+				hhcl.RangeBetween(block.OpenBraceRange, block.CloseBraceRange),
 				"module must have a \"source\" attribute",
 			))
 		}
-		modules = append(modules, ModuleBlock{Source: source})
+
+		version, _, err := findStringAttr(block, "version")
+		if err != nil {
+			errs.Append(errors.E(ErrTerraformSchema, err,
+				"looking for module.%q.version attribute", moduleName))
+		}
+
+		modules = append(modules, ModuleBlock{Source: source, Version: version})
 	}
 
 	if err := errs.AsError(); err != nil {
@@ -0,0 +1,129 @@
+// Copyright 2022 Mineiros GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package watch
+
+import (
+	"encoding/json"
+	"net"
+	"sync"
+
+	"github.com/mineiros-io/terramate/errors"
+	"github.com/rs/zerolog/log"
+)
+
+// wireEvent is the JSON representation of an Event sent to socket
+// subscribers. Module is flattened to its source/version rather than
+// embedding tf.ModuleBlock so the wire format doesn't churn every time that
+// type grows a field.
+type wireEvent struct {
+	Kind          EventKind `json:"kind"`
+	Path          string    `json:"path"`
+	ModuleSource  string    `json:"module_source,omitempty"`
+	ModuleVersion string    `json:"module_version,omitempty"`
+	Err           string    `json:"error,omitempty"`
+}
+
+// Server broadcasts a Watcher's events to any number of local clients
+// connected over a unix domain socket, so editor plugins can subscribe to
+// live changes without polling.
+type Server struct {
+	listener net.Listener
+
+	mu      sync.Mutex
+	clients map[net.Conn]struct{}
+}
+
+// Serve listens on sockPath and broadcasts every event read from events to
+// connected clients as newline-delimited JSON, until events is closed or the
+// listener is closed via Close. It returns as soon as the socket is
+// listening; accepting clients and broadcasting run in background goroutines.
+func Serve(sockPath string, events <-chan Event) (*Server, error) {
+	listener, err := net.Listen("unix", sockPath)
+	if err != nil {
+		return nil, errors.E(ErrWatch, err, "listening on %q", sockPath)
+	}
+
+	s := &Server{
+		listener: listener,
+		clients:  map[net.Conn]struct{}{},
+	}
+
+	go s.acceptLoop()
+	go s.broadcastLoop(events)
+
+	return s, nil
+}
+
+// Close stops accepting new clients and disconnects all connected ones.
+func (s *Server) Close() error {
+	err := s.listener.Close()
+
+	s.mu.Lock()
+	for conn := range s.clients {
+		conn.Close()
+	}
+	s.clients = map[net.Conn]struct{}{}
+	s.mu.Unlock()
+
+	return err
+}
+
+func (s *Server) acceptLoop() {
+	logger := log.With().Str("action", "watch.Server.acceptLoop()").Logger()
+
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			logger.Debug().Err(err).Msg("listener closed, stopping accept loop")
+			return
+		}
+
+		s.mu.Lock()
+		s.clients[conn] = struct{}{}
+		s.mu.Unlock()
+	}
+}
+
+func (s *Server) broadcastLoop(events <-chan Event) {
+	for ev := range events {
+		payload, err := json.Marshal(toWireEvent(ev))
+		if err != nil {
+			continue
+		}
+		payload = append(payload, '\n')
+
+		s.mu.Lock()
+		for conn := range s.clients {
+			if _, err := conn.Write(payload); err != nil {
+				conn.Close()
+				delete(s.clients, conn)
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+func toWireEvent(ev Event) wireEvent {
+	w := wireEvent{
+		Kind:          ev.Kind,
+		Path:          ev.Path,
+		ModuleSource:  ev.Module.Source,
+		ModuleVersion: ev.Module.Version,
+	}
+	if ev.Err != nil {
+		w.Err = ev.Err.Error()
+	}
+	return w
+}
@@ -0,0 +1,103 @@
+// Copyright 2022 Mineiros GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package watch_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mineiros-io/terramate/watch"
+)
+
+func TestServerBroadcastsEventsToConnectedClients(t *testing.T) {
+	sockPath := filepath.Join(t.TempDir(), "terramate.sock")
+
+	events := make(chan watch.Event, 1)
+	srv, err := watch.Serve(sockPath, events)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer srv.Close()
+
+	conn, err := net.Dial("unix", sockPath)
+	if err != nil {
+		t.Fatalf("dialing socket: %v", err)
+	}
+	defer conn.Close()
+
+	// acceptLoop registers the client asynchronously, so give it a moment
+	// before broadcasting - Serve doesn't block until a client connects.
+	time.Sleep(50 * time.Millisecond)
+
+	events <- watch.Event{Kind: watch.SourceChanged, Path: "main.tf"}
+
+	if err := conn.SetReadDeadline(time.Now().Add(2 * time.Second)); err != nil {
+		t.Fatalf("setting read deadline: %v", err)
+	}
+
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		t.Fatalf("reading broadcast event: %v", err)
+	}
+
+	var got struct {
+		Kind watch.EventKind `json:"kind"`
+		Path string          `json:"path"`
+	}
+	if err := json.Unmarshal([]byte(line), &got); err != nil {
+		t.Fatalf("unmarshaling broadcast event: %v", err)
+	}
+
+	if got.Kind != watch.SourceChanged {
+		t.Fatalf("got kind %v want %v", got.Kind, watch.SourceChanged)
+	}
+	if got.Path != "main.tf" {
+		t.Fatalf("got path %q want %q", got.Path, "main.tf")
+	}
+}
+
+func TestServerCloseDisconnectsClients(t *testing.T) {
+	sockPath := filepath.Join(t.TempDir(), "terramate.sock")
+
+	events := make(chan watch.Event)
+	srv, err := watch.Serve(sockPath, events)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	conn, err := net.Dial("unix", sockPath)
+	if err != nil {
+		t.Fatalf("dialing socket: %v", err)
+	}
+	defer conn.Close()
+
+	time.Sleep(50 * time.Millisecond)
+
+	if err := srv.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := conn.SetReadDeadline(time.Now().Add(2 * time.Second)); err != nil {
+		t.Fatalf("setting read deadline: %v", err)
+	}
+	buf := make([]byte, 1)
+	if _, err := conn.Read(buf); err == nil {
+		t.Fatal("expected the connection to be closed by the server, got no error reading")
+	}
+}
@@ -0,0 +1,287 @@
+// Copyright 2022 Mineiros GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package watch observes Terramate stack roots and the Terraform modules
+// they reference, incrementally re-parsing changed files and reporting what
+// changed instead of forcing a full project re-evaluation on every save.
+package watch
+
+import (
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/mineiros-io/terramate/errors"
+	"github.com/mineiros-io/terramate/hcl"
+	"github.com/mineiros-io/terramate/tf"
+	"github.com/rs/zerolog/log"
+)
+
+// EventKind classifies an Event reported on Watcher.Events().
+type EventKind int
+
+// Kinds of events a Watcher reports.
+const (
+	// ModuleAdded is sent when a "module" block appears that wasn't there
+	// on the previous parse of its file.
+	ModuleAdded EventKind = iota
+	// ModuleRemoved is sent when a previously known "module" block is gone.
+	ModuleRemoved
+	// SourceChanged is sent whenever a watched ".tm.hcl" or ".tf" file's
+	// contents change, regardless of whether its module blocks changed.
+	SourceChanged
+	// EvalError is sent when re-parsing or re-evaluating a changed file
+	// fails.
+	EvalError
+)
+
+// Event is a single change reported by a Watcher.
+type Event struct {
+	Kind   EventKind
+	Path   string         // Path is the file the event concerns.
+	Module tf.ModuleBlock // Module is set for ModuleAdded and ModuleRemoved.
+	Err    error          // Err is set for EvalError.
+}
+
+// Errors returned by the watch package.
+const (
+	ErrWatch errors.Kind = "watch error"
+)
+
+// defaultDebounce is how long the Watcher waits after the last event on a
+// path before acting on it, coalescing the handful of write+rename events a
+// single editor save usually produces into one.
+const defaultDebounce = 100 * time.Millisecond
+
+// Watcher observes a set of root directories for changes to ".tm.hcl" and
+// ".tf" files and re-parses only what changed.
+type Watcher struct {
+	fsys     hcl.FS
+	notify   *fsnotify.Watcher
+	debounce time.Duration
+	events   chan Event
+
+	mu      sync.Mutex
+	modules map[string]map[string]tf.ModuleBlock // file path -> module source -> block
+	timers  map[string]*time.Timer
+	timerWG sync.WaitGroup // tracks debounce timer callbacks currently running
+
+	done     chan struct{}
+	loopDone chan struct{} // closed once loop has returned, so Close knows no more timers can be scheduled
+}
+
+// New creates a Watcher over fsys that recursively observes every directory
+// reachable from roots. fsys must be backed by the real OS (hcl.OSFS{}, or
+// an hcl.OverlayFS over it) since fsnotify watches real filesystem paths;
+// it's threaded through mainly so re-parsing goes through the same FS
+// abstraction as the rest of the tf package. Call Close when done to
+// release the underlying fsnotify watches.
+func New(fsys hcl.FS, roots []string) (*Watcher, error) {
+	logger := log.With().Str("action", "watch.New()").Logger()
+
+	logger.Debug().Strs("roots", roots).Msg("Create fsnotify watcher")
+
+	notify, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, errors.E(ErrWatch, err, "creating fsnotify watcher")
+	}
+
+	w := &Watcher{
+		fsys:     fsys,
+		notify:   notify,
+		debounce: defaultDebounce,
+		events:   make(chan Event, 64),
+		modules:  map[string]map[string]tf.ModuleBlock{},
+		timers:   map[string]*time.Timer{},
+		done:     make(chan struct{}),
+		loopDone: make(chan struct{}),
+	}
+
+	for _, root := range roots {
+		if err := w.addRecursive(root); err != nil {
+			notify.Close()
+			return nil, err
+		}
+	}
+
+	go w.loop()
+
+	return w, nil
+}
+
+// Events returns the channel Event values are reported on. It is closed
+// when the Watcher is Closed.
+func (w *Watcher) Events() <-chan Event { return w.events }
+
+// Close stops watching and releases the underlying fsnotify resources.
+//
+// It's sequenced carefully so that nothing sends on Events() after it's
+// closed: first loop is stopped and joined, which guarantees no further
+// debounce timers get scheduled; only then are the timers still pending
+// stopped, and any already-firing one waited on, before Events() itself is
+// closed.
+func (w *Watcher) Close() error {
+	close(w.done)
+	err := w.notify.Close()
+
+	<-w.loopDone
+
+	w.mu.Lock()
+	for path, t := range w.timers {
+		if t.Stop() {
+			w.timerWG.Done()
+		}
+		delete(w.timers, path)
+	}
+	w.mu.Unlock()
+
+	w.timerWG.Wait()
+	close(w.events)
+
+	return err
+}
+
+// addRecursive adds root and every directory beneath it to the underlying
+// fsnotify watcher. fsnotify has no native recursive mode on any platform,
+// so directories are walked and added individually; newly created
+// directories are picked up as they're observed in loop.
+func (w *Watcher) addRecursive(root string) error {
+	return fs.WalkDir(w.fsys, root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if err := w.notify.Add(path); err != nil {
+			return errors.E(ErrWatch, err, "watching %q", path)
+		}
+		return nil
+	})
+}
+
+func (w *Watcher) loop() {
+	defer close(w.loopDone)
+
+	for {
+		select {
+		case <-w.done:
+			return
+		case ev, ok := <-w.notify.Events:
+			if !ok {
+				return
+			}
+			w.handleFsEvent(ev)
+		case err, ok := <-w.notify.Errors:
+			if !ok {
+				return
+			}
+			w.events <- Event{Kind: EvalError, Err: errors.E(ErrWatch, err)}
+		}
+	}
+}
+
+func (w *Watcher) handleFsEvent(ev fsnotify.Event) {
+	if ev.Op&fsnotify.Create != 0 {
+		if info, err := fs.Stat(w.fsys, ev.Name); err == nil && info.IsDir() {
+			if err := w.addRecursive(ev.Name); err != nil {
+				w.events <- Event{Kind: EvalError, Path: ev.Name, Err: err}
+			}
+			return
+		}
+	}
+
+	if !isWatchedFile(ev.Name) {
+		return
+	}
+
+	w.debounced(ev.Name, func() {
+		if ev.Op&fsnotify.Remove != 0 || ev.Op&fsnotify.Rename != 0 {
+			w.reportRemoved(ev.Name)
+			return
+		}
+		w.reparse(ev.Name)
+	})
+}
+
+// debounced schedules fn to run after w.debounce, resetting the timer if
+// another event for path arrives before it fires. fn's execution is
+// tracked in w.timerWG so Close can wait for it to finish before closing
+// Events().
+func (w *Watcher) debounced(path string, fn func()) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if t, ok := w.timers[path]; ok && t.Stop() {
+		// The stopped callback will never run, so release its slot.
+		w.timerWG.Done()
+	}
+
+	w.timerWG.Add(1)
+	w.timers[path] = time.AfterFunc(w.debounce, func() {
+		defer w.timerWG.Done()
+		fn()
+	})
+}
+
+func (w *Watcher) reportRemoved(path string) {
+	w.mu.Lock()
+	prev := w.modules[path]
+	delete(w.modules, path)
+	delete(w.timers, path)
+	w.mu.Unlock()
+
+	for _, block := range prev {
+		w.events <- Event{Kind: ModuleRemoved, Path: path, Module: block}
+	}
+}
+
+func (w *Watcher) reparse(path string) {
+	w.events <- Event{Kind: SourceChanged, Path: path}
+
+	blocks, err := tf.ParseModuleBlocks(w.fsys, path)
+	if err != nil {
+		w.events <- Event{Kind: EvalError, Path: path, Err: err}
+		return
+	}
+
+	next := make(map[string]tf.ModuleBlock, len(blocks))
+	for _, block := range blocks {
+		next[block.Source] = block
+	}
+
+	w.mu.Lock()
+	prev := w.modules[path]
+	w.modules[path] = next
+	w.mu.Unlock()
+
+	for source, block := range next {
+		if _, ok := prev[source]; !ok {
+			w.events <- Event{Kind: ModuleAdded, Path: path, Module: block}
+		}
+	}
+	for source, block := range prev {
+		if _, ok := next[source]; !ok {
+			w.events <- Event{Kind: ModuleRemoved, Path: path, Module: block}
+		}
+	}
+}
+
+func isWatchedFile(path string) bool {
+	base := filepath.Base(path)
+	return strings.HasSuffix(base, ".tm.hcl") || strings.HasSuffix(base, ".tf")
+}
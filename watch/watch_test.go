@@ -0,0 +1,90 @@
+// Copyright 2022 Mineiros GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package watch_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mineiros-io/terramate/hcl"
+	"github.com/mineiros-io/terramate/watch"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("writing %q: %v", path, err)
+	}
+}
+
+func TestWatcherReportsModuleAddedOnSave(t *testing.T) {
+	dir := t.TempDir()
+	mainTF := filepath.Join(dir, "main.tf")
+	writeFile(t, mainTF, "")
+
+	w, err := watch.New(hcl.OSFS{}, []string{dir})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer w.Close()
+
+	writeFile(t, mainTF, `
+module "child" {
+  source = "./child"
+}
+`)
+
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case ev, ok := <-w.Events():
+			if !ok {
+				t.Fatal("Events() closed before a ModuleAdded event arrived")
+			}
+			if ev.Kind == watch.ModuleAdded {
+				return
+			}
+		case <-timeout:
+			t.Fatal("timed out waiting for ModuleAdded event")
+		}
+	}
+}
+
+// TestWatcherCloseRightAfterEventDrainsEventsWithoutPanicking races Close
+// against the debounce timer an fsnotify event just scheduled - the exact
+// shutdown-ordering bug 9aed69c fixed ("send on closed channel" if Events()
+// were closed while a timer callback was still in flight). This is most
+// useful run with -race.
+func TestWatcherCloseRightAfterEventDrainsEventsWithoutPanicking(t *testing.T) {
+	dir := t.TempDir()
+	mainTF := filepath.Join(dir, "main.tf")
+	writeFile(t, mainTF, "")
+
+	w, err := watch.New(hcl.OSFS{}, []string{dir})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	writeFile(t, mainTF, `variable "x" {}`)
+
+	if err := w.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for range w.Events() {
+	}
+}